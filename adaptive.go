@@ -0,0 +1,38 @@
+package main
+
+import "math"
+
+const (
+	// adaptiveStableSamples is how much of the tail of the time series must
+	// agree before the adaptive window closes: 8 samples is two seconds at
+	// the standard interval, enough to outlast slow start's oscillation.
+	adaptiveStableSamples = 8
+	// adaptiveJitter is how far any of those samples may stray from their
+	// mean and still count as settled.
+	adaptiveJitter = 0.05
+)
+
+// stableSamples reports whether the throughput series has settled: every
+// one of the last adaptiveStableSamples samples lies within adaptiveJitter
+// of their mean. Using every-sample bounds rather than a stddev keeps a
+// single buried spike from passing as stable.
+func stableSamples(samples []float64) bool {
+	if len(samples) < adaptiveStableSamples {
+		return false
+	}
+	tail := samples[len(samples)-adaptiveStableSamples:]
+	var mean float64
+	for _, s := range tail {
+		mean += s
+	}
+	mean /= adaptiveStableSamples
+	if mean == 0 {
+		return false
+	}
+	for _, s := range tail {
+		if math.Abs(s-mean) > adaptiveJitter*mean {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ericlagergren/fast/internal/api"
+)
+
+// runBatch measures target URLs read from r, one per line, as they arrive,
+// and emits one line per input line on out — the tool as a composable
+// filter another process can drive. Blank lines and #-comments are skipped;
+// an invalid or failing target produces an error line rather than killing
+// the batch, so the output stays line-for-line with the input. EOF ends the
+// batch cleanly, and rc.timeout bounds the whole thing.
+func (rc *runConfig) runBatch(r io.Reader, out io.Writer, asJSON bool) error {
+	ctx := context.Background()
+	if rc.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rc.timeout)
+		defer cancel()
+	}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		t := api.Target{URL: line, Name: line}
+		// Each line gets the same validation a -config-file target would.
+		if err := (&api.Config{Targets: []api.Target{t}}).Validate(); err != nil {
+			batchErr(out, asJSON, line, err)
+			continue
+		}
+		tr, err := rc.measureTarget(ctx, t, 1)
+		if err != nil {
+			batchErr(out, asJSON, line, err)
+			continue
+		}
+		if asJSON {
+			if err := ndjsonTarget(out, &tr); err != nil {
+				return err
+			}
+		} else {
+			fmt.Fprintf(out, "%s\t%.3f Mbit/s\n", tr.Host, tr.Mbps)
+		}
+	}
+	return sc.Err()
+}
+
+// batchErr emits a per-line failure in the same shape as the successes, so
+// downstream consumers never lose the line-for-line correspondence.
+func batchErr(out io.Writer, asJSON bool, url string, err error) {
+	if asJSON {
+		fmt.Fprintf(out, `{"url":%q,"error":%q}`+"\n", url, err.Error())
+	} else {
+		fmt.Fprintf(out, "%s\terror: %v\n", url, err)
+	}
+}
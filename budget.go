@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// budgetBytes is the run's global data budget in bytes, shared by every
+// worker across every target and phase; 0 means unlimited. It is set once
+// from -budget before any measurement starts and never changes, so the
+// only concurrent state is the spent counter.
+var budgetBytes int64
+
+// budgetSpent counts bytes against the budget. Accessed atomically.
+var budgetSpent int64
+
+// errBudget stops a transfer that has hit the global budget. It is not a
+// failure: whatever was measured up to this point still counts.
+var errBudget = errors.New("data budget exhausted")
+
+// budgetSpend records n transferred bytes and reports whether the budget
+// is now exhausted. With no budget it is a cheap no.
+func budgetSpend(n int64) bool {
+	if budgetBytes <= 0 {
+		return false
+	}
+	return atomic.AddInt64(&budgetSpent, n) >= budgetBytes
+}
+
+// budgetExceeded reports whether the run has spent its whole budget.
+func budgetExceeded() bool {
+	return budgetBytes > 0 && atomic.LoadInt64(&budgetSpent) >= budgetBytes
+}
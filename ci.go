@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/ericlagergren/fast/internal/stats"
+)
+
+// maxCIRuns caps how many times -margin may repeat the measurement, so a
+// hopelessly noisy link still terminates.
+const maxCIRuns = 10
+
+// outliers returns the indices of values lying outside the Tukey fences,
+// i.e. more than 1.5×IQR beyond the quartiles. With fewer than four values
+// the quartiles are meaningless and nothing is rejected.
+func outliers(x []float64) []int {
+	if len(x) < 4 {
+		return nil
+	}
+	sorted := append([]float64(nil), x...)
+	sort.Float64s(sorted)
+	q1 := stats.Quantile(0.25, sorted)
+	q3 := stats.Quantile(0.75, sorted)
+	iqr := q3 - q1
+	lo, hi := q1-1.5*iqr, q3+1.5*iqr
+	var out []int
+	for i, v := range x {
+		if v < lo || v > hi {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// ciHalfWidth returns the half-width of the confidence interval of the mean
+// of x, using the t-distribution since the number of runs is small.
+func ciHalfWidth(x []float64, confidence float64) float64 {
+	n := len(x)
+	if n < 2 {
+		return math.Inf(1)
+	}
+	_, std := stats.MeanStdDev(x, nil)
+	t := stats.TQuantile(1-(1-confidence)/2, float64(n-1))
+	return t * std / math.Sqrt(float64(n))
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+)
+
+// connCount counts every new connection the measurement client dials.
+// For sequential runs, run snapshots it before and after each target to
+// attribute connections; concurrent targets (-target-parallelism) skip the
+// attribution, since one process-wide counter cannot tell their dials
+// apart. A surprising count — one per request instead of one per worker —
+// usually means keep-alive reuse is not happening, which by itself explains
+// a lot of odd throughput numbers.
+var connCount int64
+
+func takeConnCount() int64 { return atomic.LoadInt64(&connCount) }
+
+// countConns wraps dial so every successfully established connection bumps
+// connCount. Every client main might install should route its dials
+// through this.
+func countConns(dial func(context.Context, string, string) (net.Conn, error)) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := dial(ctx, network, addr)
+		if err == nil {
+			atomic.AddInt64(&connCount, 1)
+		}
+		return c, err
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// applyConvention overrides the run's defaults to approximate another
+// tool's measurement methodology, so the headline number can be compared
+// apples-to-apples with that tool's. It only touches defaults: any flag the
+// user set explicitly wins over the preset.
+//
+// The presets, and what they set:
+//
+//	ookla     approximates speedtest.net: five targets measured in
+//	          parallel with 15 s windows, so the headline number is the
+//	          combined wall-clock throughput (aggregate_mbps), the way
+//	          multi-stream tools sum their connections.
+//	netflix   this tool's own fast.com defaults — three targets measured
+//	          sequentially over 5 s windows, summarized as a weighted
+//	          mean — named so scripts can pin the methodology even if the
+//	          defaults ever change.
+//
+// Every preset reports Mbit/s; the difference between tools is methodology
+// (streams and aggregation), not units.
+func applyConvention(name string, set map[string]bool, nurls *int, window *time.Duration, targetPar *int) error {
+	pick := func(flag string, apply func()) {
+		if !set[flag] {
+			apply()
+		}
+	}
+	switch name {
+	case "ookla":
+		pick("urls", func() { *nurls = 5 })
+		pick("target-parallelism", func() { *targetPar = 5 })
+		// There is no flag for the window, so the preset owns it.
+		*window = 15 * time.Second
+	case "netflix":
+		// The defaults already are the fast.com methodology.
+	default:
+		return fmt.Errorf(`-convention: unknown preset %q (want "ookla" or "netflix")`, name)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"io"
+)
+
+// ctxReader fails reads once ctx is done, so a copy wrapped around it stops
+// promptly on cancellation instead of draining whatever the transport has
+// already buffered.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// budgetReader charges every byte read against the global -budget and stops
+// with errBudget once it is spent. It must wrap the wire-side reader — for
+// compressed bodies that is the raw response body, not the decompressed
+// stream — so the budget meters what was actually transferred.
+type budgetReader struct {
+	r io.Reader
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	if budgetExceeded() {
+		return 0, errBudget
+	}
+	n, err := b.r.Read(p)
+	if n > 0 && budgetSpend(int64(n)) && err == nil {
+		err = errBudget
+	}
+	return n, err
+}
+
+// copyContext is io.CopyBuffer bounded by ctx and the global -budget: the
+// copy aborts with ctx's error as soon as the context is cancelled, and with
+// errBudget once the budget is spent. A nil buf lets io.Copy pick its own.
+// Plain io.Copy cannot be interrupted, which would undermine -timeout and
+// SIGINT handling on bodies that keep streaming. Callers reading src through
+// a decompressor should budget the wire side themselves and copy via
+// ctxReader alone, as the gzip path in measure does.
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader, buf []byte) (int64, error) {
+	cr := &ctxReader{ctx: ctx, r: &budgetReader{r: src}}
+	if buf == nil {
+		return io.Copy(dst, cr)
+	}
+	return io.CopyBuffer(dst, cr, buf)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// TestCopyContextCancels proves cancellation actually stops a copy from a
+// source that never ends — the property -timeout and SIGINT handling both
+// lean on.
+func TestCopyContextCancels(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	type result struct {
+		n   int64
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// zeroReader yields data forever; only the context can end this.
+		n, err := copyContext(ctx, ioutil.Discard, zeroReader{}, nil)
+		done <- result{n, err}
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case r := <-done:
+		if r.err != context.Canceled {
+			t.Errorf("copy ended with %v, want %v", r.err, context.Canceled)
+		}
+		if r.n == 0 {
+			t.Error("copy moved no bytes before cancellation")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("copy did not stop after cancellation")
+	}
+}
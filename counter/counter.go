@@ -0,0 +1,29 @@
+// Package counter provides a small thread-safe byte counter.
+package counter
+
+import "sync/atomic"
+
+// BytesCounter counts bytes written to it. It is safe for concurrent use,
+// so several copy loops can share one counter, and it can be read while
+// writes are in flight, which makes it suitable for progress reporting and
+// for accounting transfers that are cancelled midway. The zero value is
+// ready to use.
+//
+// BytesCounter deliberately implements only Write, not io.ReaderFrom, so
+// io.CopyBuffer into one honors the caller's buffer.
+type BytesCounter struct {
+	n int64
+}
+
+// Write counts len(p) bytes and never fails, making the counter usable as
+// a copy destination.
+func (c *BytesCounter) Write(p []byte) (int, error) {
+	atomic.AddInt64(&c.n, int64(len(p)))
+	return len(p), nil
+}
+
+// Add counts n bytes observed out of band.
+func (c *BytesCounter) Add(n int64) { atomic.AddInt64(&c.n, n) }
+
+// N returns the number of bytes counted so far.
+func (c *BytesCounter) N() int64 { return atomic.LoadInt64(&c.n) }
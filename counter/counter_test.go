@@ -0,0 +1,60 @@
+package counter
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestBytesCounterConcurrent hammers one counter from many goroutines mixing
+// Write and Add, the way several copy loops sharing a counter do, and checks
+// nothing is lost.
+func TestBytesCounterConcurrent(t *testing.T) {
+	const (
+		workers = 16
+		rounds  = 1000
+		chunk   = 37
+	)
+	var c BytesCounter
+	var wg sync.WaitGroup
+	buf := make([]byte, chunk)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < rounds; j++ {
+				if i%2 == 0 {
+					if n, err := c.Write(buf); n != chunk || err != nil {
+						t.Errorf("Write = %d, %v; want %d, nil", n, err, chunk)
+						return
+					}
+				} else {
+					c.Add(chunk)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if want := int64(workers * rounds * chunk); c.N() != want {
+		t.Errorf("N() = %d, want %d", c.N(), want)
+	}
+}
+
+// TestBytesCounterReadableMidFlight checks N can be read while writes are in
+// flight without tripping the race detector — the property progress
+// reporting relies on.
+func TestBytesCounterReadableMidFlight(t *testing.T) {
+	var c BytesCounter
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			c.Add(1)
+		}
+	}()
+	for c.N() < 1000 {
+	}
+	<-done
+	if c.N() != 1000 {
+		t.Errorf("N() = %d, want 1000", c.N())
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ericlagergren/fast/internal/api"
+)
+
+const (
+	// diagnoseTinyBytes fits comfortably inside a single packet even on
+	// paths with a badly clamped MTU.
+	diagnoseTinyBytes = 512
+	// diagnoseWindow bounds the large-transfer probe.
+	diagnoseWindow = 3 * time.Second
+	// diagnoseStallBytes is the most a "stalled" large transfer may move:
+	// a PMTUD blackhole typically lets the handshake and the first small
+	// packets through and then drops every full-size segment.
+	diagnoseStallBytes = 64 << 10
+)
+
+// diagnose probes t for the classic path-MTU blackhole symptom: a request
+// small enough to fit in one packet succeeds, but a full-rate transfer
+// moves (almost) nothing. That pattern appears when something on the path
+// drops ICMP fragmentation-needed messages, so large segments vanish
+// silently. The output is a hint to aim further debugging, not a diagnosis;
+// plenty of middlebox misbehavior looks similar.
+func diagnose(t api.Target) error {
+	host := parseHost(t.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	fmt.Fprintf(os.Stderr, "probing %s with a %d-byte request...\n", host, diagnoseTinyBytes)
+	req, err := http.NewRequest("GET", t.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", diagnoseTinyBytes-1))
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "the tiny request failed too; the problem is more basic than MTU (DNS, routing, or the server itself):")
+		return err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, diagnoseTinyBytes))
+	resp.Body.Close()
+
+	fmt.Fprintf(os.Stderr, "tiny request ok; running a %v full-rate transfer...\n", diagnoseWindow)
+	tr, err := measure(ctx, t.URL, &measureOpts{window: diagnoseWindow})
+	switch {
+	case err != nil:
+		// An outright failure is a different symptom than a stall: the
+		// blackhole pattern is a transfer that starts and then silently
+		// moves nothing. Report the real error instead of blaming MTU.
+		fmt.Fprintf(os.Stderr, "the large transfer failed outright: %v\n", err)
+		fmt.Fprintln(os.Stderr, "that is not the path-MTU pattern; chase the error above first.")
+		return err
+	case tr.Bytes < diagnoseStallBytes:
+		fmt.Fprintf(os.Stderr, "small transfers succeed but large ones stall (%d bytes in %v)\n", tr.Bytes, diagnoseWindow)
+		fmt.Fprintln(os.Stderr, "hint: this matches a path-MTU blackhole (dropped ICMP fragmentation-needed).")
+		fmt.Fprintln(os.Stderr, "try lowering the interface MTU (e.g. 1400) or enabling TCP MTU probing; if that fixes it, the path is the culprit.")
+	default:
+		fmt.Fprintf(os.Stderr, "no MTU symptom: %.3f Mbit/s over the large transfer\n", tr.Mbps)
+	}
+	return nil
+}
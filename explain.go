@@ -0,0 +1,20 @@
+package main
+
+// explain maps a measured mean to a plain-language capability statement,
+// for users who want "is this good?" rather than a number. The thresholds
+// are deliberately coarse; streaming and calling services publish
+// recommendations in these ballparks.
+func explain(mbps float64) string {
+	switch {
+	case mbps >= 500:
+		return "great for 4K streaming on several screens, large downloads, and busy households"
+	case mbps >= 100:
+		return "comfortable for 4K streaming, video calls, and gaming"
+	case mbps >= 25:
+		return "fine for HD streaming and video calls; large downloads take a while"
+	case mbps >= 5:
+		return "enough for SD streaming and browsing; video calls may struggle"
+	default:
+		return "suitable for e-mail and light browsing only"
+	}
+}
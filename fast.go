@@ -2,103 +2,2002 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
-	"testing"
+	"syscall"
 	"text/tabwriter"
+	"time"
 
 	"github.com/ericlagergren/fast/internal/api"
-	"github.com/gonum/stat"
+	"github.com/ericlagergren/fast/internal/stats"
 )
 
+// scanURLs is how many URLs -scan requests absent an explicit -urls; the
+// API tops out at around this many.
+const scanURLs = 8
+
+// -range mode defaults: probe resumption with mid-file requests of
+// rangeProbeSize starting at rangeProbeOffset, far enough into the file
+// that a 200 response is unambiguously a server ignoring the header.
+const (
+	rangeProbeSize   = 1 << 20
+	rangeProbeOffset = 8 << 20
+)
+
+// exitLatencyExceeded is returned when -max-latency trips. It is distinct
+// from 1 (operational failure) and 2 (flag parsing) so monitoring can tell
+// "link is slow" from "test is broken".
+const exitLatencyExceeded = 3
+
 func main() {
 	var (
-		token     string
+		tokens    multiFlag
 		nurls     int
 		userAgent string
 		chatty    bool
+		influx    bool
+		cfgFile   string
+		latency   bool
+		timeout   time.Duration
+		socks5    string
+		jsonOut   bool
+		raw       bool
+
+		confidence float64
+		margin     float64
+		label      string
+
+		rateLimit     float64
+		ratePerWorker bool
+
+		watch  time.Duration
+		listen string
+		bidi   bool
+		runID  string
+
+		detectThrottling bool
+		throttleFactor   float64
+		preconn          bool
+		requestSize      int64
+		http1            bool
+		rejectOutliers   bool
+		spark            bool
+		fullFile         bool
+		sortMode         string
+		unixSocket       string
+		selfTest         bool
+		bufferSize       int
+		cpuProfile       string
+		memProfile       string
+		noRedirect       bool
+		jsonPretty       bool
+		scan             bool
+		scanWindow       time.Duration
+		ndjson           bool
+		acceptHdr        string
+		connectionHdr    string
+		weighting        string
+		verboseLevel     int
+		checkIP          bool
+		rangeMode        bool
+		output           string
+		appendOut        bool
+		rollWindow       time.Duration
+		maxTime          time.Duration
+		explainOut       bool
+		targetPar        int
+		useHTTP3         bool
+		saveConfig       string
+		only4            bool
+		only6            bool
+		compare46        bool
+		interleave       bool
+		unweighted       bool
+		tokenFile        string
+		metricsOut       bool
+		exemplars        bool
+		minIters         int64
+		noSummary        bool
+		dnsServer        string
+		minSize          int64
+		noImmediate      bool
+		spread           bool
+		historyFile      string
+		report           bool
+		failFast         bool
+		preferNear       bool
+		bestOf           int
+		diagnoseMode     bool
+		graphite         bool
+		graphitePrefix   string
+		httpProxy        string
+		maxLatency       time.Duration
+		ramp             bool
+		timingsFile      string
+		match            string
+		rotateSize       int64
+		rotateEvery      time.Duration
+		fieldsOut        bool
+		strict           bool
+		rcvbuf           int
+		adaptive         bool
+		adaptMin         time.Duration
+		adaptMax         time.Duration
+		convention       string
+		stdinMode        bool
+		verifyFile       string
+		webhook          string
+		webhookHdrs      multiFlag
+		webhookFatal     bool
+		foldedFile       string
 	)
-	flag.StringVar(&token, "token", api.DefaultToken, "api.fast.com access token")
+	flag.Var(&tokens, "token", "api.fast.com access token (repeatable; tried in order on 403/429)")
+	flag.StringVar(&tokenFile, "token-file", "", "file of api.fast.com tokens, one per line, tried after any -token")
 	flag.IntVar(&nurls, "urls", 3, "number of URLs to try")
 	flag.StringVar(&userAgent, "user-agent", api.DefaultUserAgent, "user agent to use")
-	flag.BoolVar(&chatty, "v", false, "be verbose")
+	flag.BoolVar(&chatty, "v", false, "be verbose (shortcut for -verbose-level=2)")
+	flag.IntVar(&verboseLevel, "verbose-level", levelQuiet, "diagnostic detail, 0 (quiet) through 3 (trace)")
+	flag.BoolVar(&influx, "influx", false, "print results in InfluxDB line protocol")
+	flag.StringVar(&cfgFile, "config-file", "", "measure the targets in this api.fast.com JSON config instead of fetching one")
+	flag.BoolVar(&latency, "latency", false, "probe each target's unloaded latency before measuring")
+	flag.DurationVar(&timeout, "timeout", 0, "overall time budget for the run, split across targets (0 = none)")
+	flag.StringVar(&socks5, "socks5", "", "SOCKS5 proxy to measure through ([user:pass@]host:port)")
+	flag.BoolVar(&jsonOut, "json", false, "print results as JSON")
+	flag.BoolVar(&raw, "raw", false, "print every iteration's bytes and duration as it completes")
+	flag.Float64Var(&confidence, "confidence", 0.95, "confidence level used with -margin")
+	flag.Float64Var(&margin, "margin", 0, "repeat runs until the CI of the mean is within this fraction of it (0 = run once)")
+	flag.StringVar(&label, "label", "", `tag for this run (e.g. "wifi-kitchen"), recorded in structured output`)
+	flag.Float64Var(&rateLimit, "rate-limit", 0, "throttle downloads to this many Mbit/s (0 = unlimited)")
+	flag.BoolVar(&ratePerWorker, "rate-limit-per-worker", false, "apply -rate-limit to each worker instead of the aggregate")
+	flag.DurationVar(&watch, "watch", 0, "re-run the measurement at this interval forever (0 = run once)")
+	flag.StringVar(&listen, "listen", "", "serve /healthz on this address while watching (e.g. :8080)")
+	flag.BoolVar(&bidi, "bidirectional", false, "saturate upload and download at the same time")
+	flag.StringVar(&runID, "run-id", "", "correlation ID recorded with the run (default: a random UUID per run)")
+	flag.BoolVar(&detectThrottling, "detect-throttle", false, "analyze the throughput time series for ISP throttling")
+	flag.Float64Var(&throttleFactor, "throttle-factor", defaultThrottleFactor, "flag throttling when the plateau falls below this fraction of the peak")
+	flag.BoolVar(&preconn, "preconnect", false, "establish connections to all targets before timing begins")
+	flag.Int64Var(&requestSize, "request-size", 0, "issue many requests of this many bytes instead of streaming (0 = stream)")
+	flag.IntVar(&tableMaxWidth, "max-width", 0, "truncate host and ISP names longer than this in the table (0 = no limit)")
+	flag.BoolVar(&tableShortNames, "short-names", false, "show only the distinguishing part of each hostname in the table")
+	flag.IntVar(&tableMinWidth, "table-width", tableMinWidth, "minimum table column width in characters")
+	flag.IntVar(&tablePadding, "table-padding", tablePadding, "spaces between table columns")
+	flag.BoolVar(&http1, "http1", false, "force HTTP/1.1 for the config fetch and downloads")
+	flag.BoolVar(&rejectOutliers, "reject-outliers", false, "exclude targets outside 1.5×IQR from the summary statistics")
+	flag.BoolVar(&spark, "sparkline", false, "show a throughput sparkline per target (TTY only)")
+	flag.BoolVar(&fullFile, "full-file", false, "download each target's file exactly once instead of sampling a window")
+	flag.StringVar(&sortMode, "sort", "", "order rows by speed-desc, speed-asc, host, or latency (default: API order)")
+	flag.StringVar(&unixSocket, "unix-socket", "", "dial this Unix socket for every connection (testing only)")
+	flag.BoolVar(&selfTest, "selftest", false, "measure a local known-rate server and verify the result")
+	flag.IntVar(&bufferSize, "buffer-size", defaultBufferSize, "read buffer size in bytes used while draining responses")
+	flag.StringVar(&cpuProfile, "cpuprofile", "", "write a CPU profile to this file")
+	flag.StringVar(&memProfile, "memprofile", "", "write a heap profile to this file on exit")
+	flag.BoolVar(&noRedirect, "no-redirect", false, "treat redirects on target downloads as errors")
+	flag.BoolVar(&jsonPretty, "json-pretty", false, "indent -json output for human readers")
+	flag.BoolVar(&scan, "scan", false, "benchmark every target the API will return and rank them by speed")
+	flag.DurationVar(&scanWindow, "scan-window", 2*time.Second, "per-target measurement window in -scan mode")
+	flag.BoolVar(&ndjson, "ndjson", false, "stream one JSON object per target as it completes")
+	flag.StringVar(&acceptHdr, "accept", "", `Accept header for measurement requests (default: Go's "*/*")`)
+	flag.StringVar(&connectionHdr, "connection", "", `Connection header for measurement requests ("close" re-handshakes every request)`)
+	flag.StringVar(&weighting, "weight", "iters", "weight targets in the summary by iters, bytes, or none")
+	flag.BoolVar(&unweighted, "unweighted", false, "count every target equally in the summary (same as -weight none)")
+	flag.BoolVar(&checkIP, "check-ip", false, "with -watch, re-fetch the config each cycle and flag public IP changes")
+	flag.BoolVar(&rangeMode, "range", false, "probe mid-file Range resumption and measure ranged-request throughput")
+	flag.StringVar(&output, "output", "", "write results to this file instead of stdout")
+	flag.StringVar(&output, "o", "", "shorthand for -output")
+	flag.BoolVar(&appendOut, "append", false, "with -output, append instead of truncating")
+	flag.DurationVar(&rollWindow, "rolling", 0, "with -watch, report p50/p90/p99 over this much recent history (0 = off)")
+	flag.DurationVar(&maxTime, "max-time", 0, "hard ceiling on any single target's measurement (0 = none)")
+	flag.BoolVar(&explainOut, "explain", false, "add a plain-language interpretation of the result")
+	flag.IntVar(&targetPar, "target-parallelism", 1, "measure this many targets concurrently (they compete for bandwidth)")
+	flag.BoolVar(&useHTTP3, "http3", false, "measure over HTTP/3 (experimental; requires building with -tags http3)")
+	flag.StringVar(&saveConfig, "save-config", "", "save the fetched config JSON to this file for later -config-file replay")
+	flag.BoolVar(&only4, "4", false, "force IPv4")
+	flag.BoolVar(&only6, "6", false, "force IPv6")
+	flag.BoolVar(&compare46, "46", false, "measure over IPv4 and IPv6 and compare")
+	flag.BoolVar(&interleave, "interleave", false, "alternate download then upload per target, reusing connections")
+	flag.BoolVar(&metricsOut, "metrics", false, "with -listen, serve the latest result on /metrics in OpenMetrics format")
+	flag.BoolVar(&exemplars, "exemplars", false, "attach run-id exemplars to -metrics samples (needs an OpenMetrics-aware scraper)")
+	flag.Int64Var(&minIters, "min-iters", 0, "complete at least this many downloads per target, extending the window if needed")
+	flag.BoolVar(&noSummary, "no-summary", false, "print only per-target results, not the aggregate summary")
+	flag.StringVar(&dnsServer, "dns", "", "resolve hostnames via this DNS server (host:port, e.g. 1.1.1.1:53)")
+	flag.Int64Var(&minSize, "min-size", 0, "prefer targets whose files are at least this many bytes (probed via HEAD)")
+	flag.BoolVar(&noImmediate, "no-immediate", false, "with -watch, wait one interval before the first measurement instead of running at once")
+	flag.BoolVar(&spread, "spread", false, "pull requests round-robin across all targets and report one combined number")
+	flag.StringVar(&historyFile, "history", "", "append each result as a JSON line to this file")
+	flag.BoolVar(&report, "report", false, "summarize the -history file grouped by ISP/ASN and exit")
+	flag.BoolVar(&failFast, "fail-fast", false, "abort immediately if the first target cannot be connected to")
+	flag.BoolVar(&preferNear, "prefer-near", false, "measure targets nearest the client first, by advertised coordinates")
+	flag.IntVar(&bestOf, "best-of", 1, "run the whole measurement this many times and report the fastest run")
+	flag.BoolVar(&diagnoseMode, "diagnose", false, "probe the first target for MTU/fragmentation symptoms and exit")
+	flag.BoolVar(&graphite, "graphite", false, "print results in Graphite plaintext protocol")
+	flag.StringVar(&graphitePrefix, "graphite-prefix", "fast", "metric path prefix for -graphite output")
+	flag.StringVar(&httpProxy, "proxy", "", "HTTP proxy to tunnel through ([user:pass@]host:port; auth is sent on CONNECT)")
+	flag.DurationVar(&maxLatency, "max-latency", 0, "exit with status 3 if the best probed latency exceeds this (needs -latency)")
+	flag.BoolVar(&ramp, "ramp", false, "report how long each target took to reach 90% of its peak throughput")
+	flag.StringVar(&timingsFile, "timings", "", "write every request's start, TTFB, duration, and bytes as CSV to this file")
+	flag.StringVar(&match, "match", "", "measure only targets whose hostname matches this regexp")
+	flag.Int64Var(&rotateSize, "rotate-size", 0, "with -output, rotate the file when it exceeds this many bytes (0 = never)")
+	flag.DurationVar(&rotateEvery, "rotate-every", 0, "with -output, rotate the file at this age, e.g. 24h (0 = never)")
+	flag.BoolVar(&fieldsOut, "fields", false, `print unpadded "host mbps iters" lines for awk and cut`)
+	flag.BoolVar(&strict, "strict", false, "abort the whole run on the first target error instead of continuing without it")
+	flag.IntVar(&rcvbuf, "rcvbuf", 0, "request this SO_RCVBUF in bytes on every connection (0 = kernel default)")
+	flag.BoolVar(&adaptive, "adaptive", false, "end each target's window once throughput settles, within the -adaptive-min/-adaptive-max bounds")
+	flag.DurationVar(&adaptMin, "adaptive-min", 2*time.Second, "shortest window -adaptive may choose")
+	flag.DurationVar(&adaptMax, "adaptive-max", 15*time.Second, "longest window -adaptive may choose")
+	flag.StringVar(&convention, "convention", "", `measure the way another tool does: "ookla" or "netflix"`)
+	flag.BoolVar(&stdinMode, "stdin", false, "measure target URLs read from stdin, one per line, emitting one result line each")
+	flag.StringVar(&verifyFile, "verify", "", `with -full-file, verify downloads against this checksum file ("<sha256-hex> <url>" lines)`)
+	flag.StringVar(&webhook, "webhook", "", "POST each run's JSON summary to this URL when it completes")
+	flag.Var(&webhookHdrs, "webhook-header", `extra header for the webhook POST, as "Name: value" (repeatable)`)
+	flag.BoolVar(&webhookFatal, "webhook-fatal", false, "treat a failed webhook POST as fatal instead of a warning")
+	flag.Int64Var(&budgetBytes, "budget", 0, "stop the whole run after this many bytes across all targets and phases (0 = unlimited)")
+	flag.StringVar(&foldedFile, "folded", "", "write aggregated per-phase timings (dns;connect;tls;...) to this file in flamegraph folded format")
 	flag.Parse()
 
-	if chatty {
-		fmt.Fprintln(os.Stderr, "Retrieving fast.com configuration...")
-	}
-	c, err := api.Load(
-		api.WithToken(token),
-		api.NumURLs(nurls),
-		api.WithUserAgent(userAgent),
-	)
+	settings, err := loadSettings()
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := applySettings(settings); err != nil {
+		log.Fatal(err)
+	}
+
+	// On very fast links the tool itself can be CPU-bound; profiles tell
+	// users whether the client, not the link, is the bottleneck.
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			log.Fatal(err)
+		}
+	}
+	stopProfiles := func() {
+		if cpuProfile != "" {
+			pprof.StopCPUProfile()
+		}
+		if memProfile != "" {
+			writeMemProfile(memProfile)
+		}
+	}
+	if cpuProfile != "" || memProfile != "" {
+		defer stopProfiles()
+		// The long-running cases profiling exists for — a -watch exporter
+		// chief among them — end by signal, never by falling off the end of
+		// main, and a deferred stop does not run then. Catch the signal so
+		// the profile still lands on disk.
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sig
+			stopProfiles()
+			os.Exit(1)
+		}()
+	}
+
+	if targetPar < 1 {
+		log.Fatal("-target-parallelism: must be at least 1")
+	}
+	if bufferSize < minBufferSize {
+		log.Fatalf("-buffer-size: %d is below the %d byte minimum", bufferSize, minBufferSize)
+	}
+
+	if selfTest {
+		if err := selftest(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	// The flag set has grown enough that bad combinations deserve precise
+	// errors up front, before any work happens, rather than whichever
+	// confusing behavior happens to win.
+	conflict := func(a, b string, bad bool) {
+		if bad {
+			log.Fatalf("-%s and -%s are mutually exclusive", a, b)
+		}
+	}
+	requires := func(name, dep string, bad bool) {
+		if bad {
+			log.Fatalf("-%s requires -%s", name, dep)
+		}
+	}
+	if only4 && only6 {
+		log.Fatal("both -4 and -6 given; use -46 to compare the families")
+	}
+	conflict("46", "4", compare46 && only4)
+	conflict("46", "6", compare46 && only6)
+	conflict("46", "watch", compare46 && watch > 0)
+	conflict("json", "influx", jsonOut && influx)
+	conflict("json", "ndjson", jsonOut && ndjson)
+	conflict("influx", "ndjson", influx && ndjson)
+	conflict("graphite", "json", graphite && jsonOut)
+	conflict("graphite", "influx", graphite && influx)
+	conflict("graphite", "ndjson", graphite && ndjson)
+	requires("graphite-prefix", "graphite", set["graphite-prefix"] && !graphite)
+	conflict("fields", "json", fieldsOut && jsonOut)
+	conflict("fields", "influx", fieldsOut && influx)
+	conflict("fields", "ndjson", fieldsOut && ndjson)
+	conflict("fields", "graphite", fieldsOut && graphite)
+	conflict("http1", "http3", http1 && useHTTP3)
+	conflict("full-file", "request-size", fullFile && requestSize > 0)
+	conflict("full-file", "min-iters", fullFile && minIters > 0)
+	conflict("full-file", "range", fullFile && rangeMode)
+	conflict("full-file", "bidirectional", fullFile && bidi)
+	conflict("bidirectional", "interleave", bidi && interleave)
+	conflict("full-file", "interleave", fullFile && interleave)
+	conflict("spread", "full-file", spread && fullFile)
+	conflict("spread", "interleave", spread && interleave)
+	conflict("spread", "bidirectional", spread && bidi)
+	conflict("spread", "latency", spread && latency)
+	conflict("spread", "target-parallelism", spread && set["target-parallelism"])
+	conflict("socks5", "unix-socket", socks5 != "" && unixSocket != "")
+	conflict("proxy", "socks5", httpProxy != "" && socks5 != "")
+	conflict("proxy", "unix-socket", httpProxy != "" && unixSocket != "")
+	// A SOCKS5 proxy resolves hostnames on the far side, so a local
+	// resolver override would silently not apply.
+	conflict("dns", "socks5", dnsServer != "" && socks5 != "")
+	conflict("dns", "unix-socket", dnsServer != "" && unixSocket != "")
+	// Both flags want to own the dialer; composing them is not worth the
+	// plumbing for such a niche pairing.
+	conflict("rcvbuf", "dns", rcvbuf > 0 && dnsServer != "")
+	conflict("rcvbuf", "socks5", rcvbuf > 0 && socks5 != "")
+	conflict("rcvbuf", "unix-socket", rcvbuf > 0 && unixSocket != "")
+	// The fail-fast probe dials directly, which says nothing about a
+	// proxied or socket-injected path.
+	conflict("fail-fast", "socks5", failFast && socks5 != "")
+	conflict("fail-fast", "unix-socket", failFast && unixSocket != "")
+	requires("json-pretty", "json", jsonPretty && !jsonOut)
+	requires("append", "output", appendOut && output == "")
+	requires("rotate-size", "output", rotateSize > 0 && output == "")
+	requires("rotate-every", "output", rotateEvery > 0 && output == "")
+	requires("check-ip", "watch", checkIP && watch == 0)
+	requires("rolling", "watch", rollWindow > 0 && watch == 0)
+	requires("no-immediate", "watch", noImmediate && watch == 0)
+	requires("report", "history", report && historyFile == "")
+	requires("max-latency", "latency", maxLatency > 0 && !latency)
+	conflict("max-latency", "watch", maxLatency > 0 && watch > 0)
+	if bestOf < 1 {
+		log.Fatal("-best-of: must be at least 1")
+	}
+	conflict("best-of", "watch", bestOf > 1 && watch > 0)
+	conflict("best-of", "margin", bestOf > 1 && margin > 0)
+	conflict("best-of", "46", bestOf > 1 && compare46)
+	requires("rate-limit-per-worker", "rate-limit", ratePerWorker && rateLimit == 0)
+	requires("metrics", "listen", metricsOut && listen == "")
+	requires("exemplars", "metrics", exemplars && !metricsOut)
+	requires("scan-window", "scan", set["scan-window"] && !scan)
+	// Only a complete download has well-defined content to hash; a sampled
+	// window stops mid-stream.
+	requires("verify", "full-file", verifyFile != "" && !fullFile)
+	// The budget is spent once, for good; a second watch cycle would start
+	// already exhausted and measure nothing.
+	conflict("budget", "watch", budgetBytes > 0 && watch > 0)
+	requires("webhook-header", "webhook", len(webhookHdrs) > 0 && webhook == "")
+	requires("webhook-fatal", "webhook", webhookFatal && webhook == "")
+	var webhookHdr http.Header
+	if len(webhookHdrs) > 0 {
+		webhookHdr = make(http.Header)
+		for _, h := range webhookHdrs {
+			i := strings.IndexByte(h, ':')
+			if i <= 0 {
+				log.Fatalf(`-webhook-header: %q is not "Name: value"`, h)
+			}
+			webhookHdr.Set(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+		}
+	}
+	requires("adaptive-min", "adaptive", set["adaptive-min"] && !adaptive)
+	requires("adaptive-max", "adaptive", set["adaptive-max"] && !adaptive)
+	conflict("adaptive", "full-file", adaptive && fullFile)
+	conflict("adaptive", "scan", adaptive && scan)
+	conflict("convention", "scan", convention != "" && scan)
+	conflict("convention", "spread", convention != "" && spread)
+	conflict("convention", "full-file", convention != "" && fullFile)
+	conflict("convention", "adaptive", convention != "" && adaptive)
+	// Batch mode has no API config, so everything that reads or reshapes
+	// one is out, as are the whole-run modes.
+	conflict("stdin", "config-file", stdinMode && cfgFile != "")
+	conflict("stdin", "save-config", stdinMode && saveConfig != "")
+	conflict("stdin", "match", stdinMode && match != "")
+	conflict("stdin", "min-size", stdinMode && minSize > 0)
+	conflict("stdin", "prefer-near", stdinMode && preferNear)
+	conflict("stdin", "fail-fast", stdinMode && failFast)
+	conflict("stdin", "diagnose", stdinMode && diagnoseMode)
+	conflict("stdin", "check-ip", stdinMode && checkIP)
+	conflict("stdin", "watch", stdinMode && watch > 0)
+	conflict("stdin", "scan", stdinMode && scan)
+	conflict("stdin", "46", stdinMode && compare46)
+	conflict("stdin", "best-of", stdinMode && bestOf > 1)
+	conflict("stdin", "spread", stdinMode && spread)
+	conflict("stdin", "margin", stdinMode && margin > 0)
+	// The folded log is written on Close, which -watch's endless loop
+	// never reaches; rejecting the pair beats silently writing nothing.
+	conflict("folded", "watch", foldedFile != "" && watch > 0)
+	if adaptive && (adaptMin <= 0 || adaptMax < adaptMin) {
+		log.Fatal("-adaptive needs 0 < -adaptive-min <= -adaptive-max")
+	}
+	conflict("unweighted", "weight", unweighted && set["weight"] && weighting != "none")
+	if unweighted {
+		weighting = "none"
+	}
+
+	if report {
+		if err := reportHistory(os.Stdout, historyFile); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// -v is a shortcut for debug-level diagnostics; an explicit
+	// -verbose-level wins when both are given.
+	if chatty && !set["verbose-level"] {
+		verboseLevel = levelDebug
+	}
+	if verboseLevel < levelQuiet || verboseLevel > levelTrace {
+		log.Fatalf("-verbose-level: %d is out of range [%d, %d]", verboseLevel, levelQuiet, levelTrace)
+	}
+	verbosity = verboseLevel
+	chatty = verbose(levelInfo)
+
+	if tableMinWidth < 1 || tablePadding < 0 {
+		log.Fatal("-table-width must be at least 1 and -table-padding at least 0")
+	}
+	// On a narrow terminal the default columns wrap into unreadability;
+	// when the shell exports COLUMNS and the user did not pick a width,
+	// shrink the columns to fit. (There are up to six of them.)
+	if !set["table-width"] && isTTY(os.Stdout) {
+		if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 0 {
+			if w := cols / 6; w < tableMinWidth {
+				tableMinWidth = w
+			}
+		}
+	}
+
+	// -scan is sugar: ask for as many URLs as the API will give, keep each
+	// target's window short so the total stays bounded, and rank the rows.
+	// Explicit flags still win.
+	var window time.Duration
+	if scan {
+		if !set["urls"] {
+			nurls = scanURLs
+		}
+		if sortMode == "" {
+			sortMode = "speed-desc"
+		}
+		window = scanWindow
+	}
+
+	// -range rides on the -request-size machinery, just starting each
+	// request mid-file instead of at byte zero.
+	var rangeOffset int64
+	if rangeMode {
+		if requestSize == 0 {
+			requestSize = rangeProbeSize
+		}
+		rangeOffset = rangeProbeOffset
+	}
+
+	if convention != "" {
+		if err := applyConvention(convention, set, &nurls, &window, &targetPar); err != nil {
+			log.Fatal(err)
+		}
+		w := window
+		if w == 0 {
+			w = defaultWindow
+		}
+		logf(levelInfo, "convention %s: %d targets, parallelism %d, %v windows\n",
+			convention, nurls, targetPar, w)
+	}
+
+	switch sortMode {
+	case "", "host", "speed-asc", "speed-desc", "latency":
+	default:
+		log.Fatalf("-sort: unknown mode %q", sortMode)
+	}
+
+	switch weighting {
+	case "iters", "bytes", "none":
+	default:
+		log.Fatalf("-weight: unknown scheme %q", weighting)
+	}
+
+	if rcvbuf > 0 {
+		if rcvbufSupported {
+			httpClient = rcvbufClient(httpClient, rcvbuf)
+		} else {
+			fmt.Fprintln(os.Stderr, "-rcvbuf: not supported on this platform; using the kernel default")
+		}
+	}
+	if dnsServer != "" {
+		if _, _, err := net.SplitHostPort(dnsServer); err != nil {
+			log.Fatalf("-dns: %v (want host:port, e.g. 1.1.1.1:53)", err)
+		}
+		httpClient = withResolver(httpClient, dnsServer)
+	}
+	if httpProxy != "" {
+		hc, err := httpProxyClient(httpClient, httpProxy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		httpClient = hc
+	}
+	if socks5 != "" {
+		hc, err := socks5Client(httpClient, socks5)
+		if err != nil {
+			log.Fatal(err)
+		}
+		httpClient = hc
+	}
+	if unixSocket != "" {
+		httpClient = unixSocketClient(httpClient, unixSocket)
+	}
+	if http1 {
+		httpClient = disableHTTP2(httpClient)
+	}
+	if useHTTP3 {
+		hc, err := http3Client()
+		if err != nil {
+			log.Fatal(err)
+		}
+		httpClient = hc
+	}
+	if only4 {
+		httpClient = forceNetwork(httpClient, "tcp4")
+	}
+	if only6 {
+		httpClient = forceNetwork(httpClient, "tcp6")
+	}
+	if noRedirect {
+		httpClient = noRedirectClient(httpClient)
+	}
+
+	if checkIP && cfgFile != "" {
+		log.Fatal("-check-ip requires fetching the config, not -config-file")
+	}
+	if tokenFile != "" {
+		ts, err := readTokenFile(tokenFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tokens = append(tokens, ts...)
+	}
+
+	// Compile the pattern before any network work so a typo fails fast.
+	var matchRE *regexp.Regexp
+	if match != "" {
+		re, err := regexp.Compile(match)
+		if err != nil {
+			log.Fatalf("-match: %v", err)
+		}
+		matchRE = re
+	}
+
+	// loadConfig fetches a config and applies the target-shaping flags —
+	// -match, -min-size, -prefer-near — plus a validity check, so a
+	// -watch -check-ip refresh honors the same contract as the initial
+	// load instead of quietly reverting to the unfiltered target list.
+	loadConfig := func() (*api.Config, error) {
+		var c *api.Config
+		var err error
+		if cfgFile != "" {
+			c, err = loadConfigFile(cfgFile)
+		} else {
+			if chatty {
+				fmt.Fprintln(os.Stderr, "Retrieving fast.com configuration...")
+			}
+			c, err = api.Load(
+				api.WithClient(httpClient),
+				api.WithTokens(tokens...),
+				api.NumURLs(nurls),
+				api.WithUserAgent(userAgent),
+				// Warm each target's DNS as it parses out of the response
+				// stream, overlapping resolution with the config fetch. Full
+				// connection setup is left to -preconnect's own timed pass in
+				// run(); priming here too would make that pass hit warm
+				// connections and under-report PreconnectMS.
+				api.WithTargetFunc(func(t api.Target) {
+					go func(url string) {
+						host := parseHost(url)
+						if h, _, err := net.SplitHostPort(host); err == nil {
+							host = h
+						}
+						net.DefaultResolver.LookupHost(context.Background(), host)
+					}(t.URL)
+				}),
+			)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The API can legally return zero targets; everything below
+		// assumes at least one, so bail out here rather than panic on
+		// the first c.Targets[0].
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+		if matchRE != nil {
+			kept := matchTargets(c.Targets, matchRE)
+			if len(kept) == 0 {
+				return nil, fmt.Errorf("-match %q matched none of the %d targets", match, len(c.Targets))
+			}
+			logf(levelInfo, "%d of %d targets match %q\n", len(kept), len(c.Targets), match)
+			c.Targets = kept
+		}
+		if minSize > 0 {
+			preferLargeFiles(c.Targets, minSize)
+		}
+		if preferNear {
+			sortByDistance(c)
+		}
+		return c, nil
+	}
+	// Batch mode measures URLs supplied on stdin, so there is no config to
+	// load and no client/ISP context to print.
+	var c *api.Config
+	if !stdinMode {
+		var err error
+		c, err = loadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if saveConfig != "" {
+		if cfgFile != "" {
+			log.Fatal("-save-config requires fetching the config, not -config-file")
+		}
+		// Save the raw bytes, not a re-marshal, so the fixture preserves
+		// fields this version does not parse.
+		if err := ioutil.WriteFile(saveConfig, c.Raw(), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	if chatty {
+	if chatty && c != nil {
+		if len(tokens) > 1 {
+			fmt.Fprintf(os.Stderr, "using token %d of %d\n", c.UsedToken()+1, len(tokens))
+		}
 		isp := c.Client.ISP
 		if isp == "" {
 			isp = "???"
 		}
-		fmt.Fprintf(os.Stderr, "Testing from %s (%s)...\n\n", isp, c.Client.IP)
+		fmt.Fprintf(os.Stderr, "Testing from %s (%s)...\n", truncate(isp, tableMaxWidth), c.Client.IP)
+		// Echo where fast.com thinks the client is, so a stale GeoIP entry
+		// (and the far-away targets it causes) is easy to spot.
+		if loc := c.Client.Location; loc.String() != "" {
+			fmt.Fprintf(os.Stderr, "Location: %s\n", loc)
+			if loc.HasCoords() {
+				fmt.Fprintf(os.Stderr, "Map: https://www.openstreetmap.org/?mlat=%g&mlon=%g\n", loc.Latitude, loc.Longitude)
+			}
+		}
+		fmt.Fprintln(os.Stderr)
+		if got := len(c.Targets); got < c.RequestedURLs() {
+			fmt.Fprintf(os.Stderr, "Warning: requested %d URLs, got %d; the aggregate is based on fewer samples\n\n",
+				c.RequestedURLs(), got)
+		}
 	}
 
-	w := new(tabwriter.Writer)
-	initWriter(w)
+	// Results go to stdout unless -output names a file; diagnostics stay
+	// on stderr either way, so the file holds only the results.
+	out := io.Writer(os.Stdout)
+	switch {
+	case output != "" && (rotateSize > 0 || rotateEvery > 0):
+		// The rotating sink always appends, so a restarted watch resumes
+		// the existing file rather than clobbering it.
+		rf, err := openRotatingFile(output, rotateSize, rotateEvery)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err := rf.Close(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+		out = rf
+	case output != "":
+		mode := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if appendOut {
+			mode = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		f, err := os.OpenFile(output, mode, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+		out = f
+	}
+
+	rc := &runConfig{
+		latency: latency,
+		raw:     raw,
+		jsonOut: jsonOut,
+		chatty:  chatty,
+		timeout: timeout,
+		label:   label,
+		runID:   runID,
+
+		rateLimit:     rateLimit,
+		ratePerWorker: ratePerWorker,
+		bidi:          bidi,
+		interleave:    interleave,
+
+		detectThrottling: detectThrottling,
+		throttleFactor:   throttleFactor,
+		preconnect:       preconn,
+		requestSize:      requestSize,
+		rangeOffset:      rangeOffset,
+		rejectOutliers:   rejectOutliers,
+		fullFile:         fullFile,
+		bufferSize:       bufferSize,
+		window:           window,
+		minIters:         minIters,
+		spread:           spread,
+		ramp:             ramp,
+		weighting:        weighting,
+		maxTime:          maxTime,
+		strict:           strict,
+		parallelism:      targetPar,
+		// Sparklines are ergonomics for humans watching a terminal; keep
+		// them out of pipelines and structured output.
+		sparkline: spark && !jsonOut && !influx && !ndjson && !graphite && !fieldsOut && isTTY(os.Stderr),
+	}
+	if adaptive {
+		rc.adaptMin, rc.adaptMax = adaptMin, adaptMax
+	}
+	if verifyFile != "" {
+		sums, err := loadChecksums(verifyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rc.checksums = sums
+	}
+	if timingsFile != "" {
+		tl, err := openTimingsLog(timingsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err := tl.Close(); err != nil {
+				log.Print(err)
+			}
+		}()
+		rc.timings = tl
+	}
+	if foldedFile != "" {
+		fl, err := openFoldedLog(foldedFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// The aggregate is only written on Close, once every request is in.
+		defer func() {
+			if err := fl.Close(); err != nil {
+				log.Print(err)
+			}
+		}()
+		rc.folded = fl
+	}
+	if acceptHdr != "" || connectionHdr != "" {
+		rc.header = make(http.Header)
+		if acceptHdr != "" {
+			rc.header.Set("Accept", acceptHdr)
+		}
+		if connectionHdr != "" {
+			rc.header.Set("Connection", connectionHdr)
+		}
+	}
+	if ndjson {
+		rc.onTarget = func(tr *TargetResult) {
+			if err := ndjsonTarget(out, tr); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	emit := func(res *Result) {
+		sortTargets(res, sortMode)
+		switch {
+		case ndjson:
+			if noSummary {
+				break
+			}
+			if err := ndjsonSummary(out, res); err != nil {
+				log.Fatal(err)
+			}
+		case influx:
+			writeInflux(out, res, !noSummary)
+		case graphite:
+			writeGraphite(out, res, graphitePrefix, !noSummary)
+		case fieldsOut:
+			writeFields(out, res)
+		case jsonOut:
+			if err := writeJSON(out, res, jsonPretty, !noSummary); err != nil {
+				log.Fatal(err)
+			}
+		default:
+			writeTable(out, res, !noSummary)
+		}
+		if historyFile != "" {
+			if err := appendHistory(historyFile, res); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if webhook != "" {
+			// A broken collector should not cost the measurement unless
+			// the user says so.
+			if err := postWebhook(webhook, res, webhookHdr, timeout); err != nil {
+				if webhookFatal {
+					log.Fatalf("webhook: %v", err)
+				}
+				fmt.Fprintf(os.Stderr, "webhook: %v\n", err)
+			}
+		}
+		// The interpretation goes to stderr so structured output and
+		// -output files stay machine-clean.
+		if explainOut {
+			fmt.Fprintf(os.Stderr, "~%.0f Mbit/s: %s\n", res.Mean, explain(res.Mean))
+		}
+	}
+
+	if stdinMode {
+		if err := rc.runBatch(os.Stdin, out, jsonOut); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if failFast {
+		if err := checkFirstTarget(c.Targets[0]); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if diagnoseMode {
+		if err := diagnose(c.Targets[0]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// checkMaxLatency enforces -max-latency on a finished result, exiting
+	// with the documented status codes. Every path that emits a final
+	// result must run it, so monitoring scripts get the same contract
+	// from -best-of and -46 as from a single run.
+	checkMaxLatency := func(res *Result) {
+		if maxLatency <= 0 {
+			return
+		}
+		best := time.Duration(math.MaxInt64)
+		for _, t := range res.Targets {
+			if t.Latency != nil && t.Latency.Min < best {
+				best = t.Latency.Min
+			}
+		}
+		switch {
+		case best == time.Duration(math.MaxInt64):
+			fmt.Fprintln(os.Stderr, "-max-latency: no latency probe succeeded")
+			os.Exit(1)
+		case best > maxLatency:
+			fmt.Fprintf(os.Stderr, "best latency %v exceeds -max-latency %v\n", best, maxLatency)
+			os.Exit(exitLatencyExceeded)
+		}
+	}
+
+	if compare46 {
+		// Run the whole test once per family, sequentially, so the runs
+		// do not compete for the link.
+		base := httpClient
+		var (
+			means   [2]float64
+			reached [2]bool
+			results [2]*Result
+		)
+		families := []struct{ name, network string }{
+			{"IPv4", "tcp4"},
+			{"IPv6", "tcp6"},
+		}
+		for i, f := range families {
+			httpClient = forceNetwork(base, f.network)
+			fr := *rc
+			if fr.label != "" {
+				fr.label += "-"
+			}
+			fr.label += strings.ToLower(f.name)
+			fmt.Fprintf(os.Stderr, "--- %s ---\n", f.name)
+			res, err := run(c, &fr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s unavailable: %v\n", f.name, err)
+				continue
+			}
+			emit(res)
+			means[i], reached[i], results[i] = res.Mean, true, res
+		}
+		switch {
+		case reached[0] && reached[1]:
+			slower := means[0]
+			if means[1] < slower {
+				slower = means[1]
+			}
+			faster := means[0] + means[1] - slower
+			if faster > 0 && (faster-slower)/faster >= 0.2 {
+				fmt.Fprintf(os.Stderr, "significant asymmetry: IPv4 %.3f vs IPv6 %.3f Mbit/s\n", means[0], means[1])
+			} else {
+				fmt.Fprintf(os.Stderr, "IPv4 %.3f vs IPv6 %.3f Mbit/s\n", means[0], means[1])
+			}
+		case reached[0]:
+			fmt.Fprintln(os.Stderr, "only IPv4 is reachable; results above are IPv4-only")
+		case reached[1]:
+			fmt.Fprintln(os.Stderr, "only IPv6 is reachable; results above are IPv6-only")
+		default:
+			log.Fatal("neither address family completed a measurement")
+		}
+		// The threshold applies after the comparison summary, so the
+		// exit explains itself even when one family trips it.
+		for _, res := range results {
+			if res != nil {
+				checkMaxLatency(res)
+			}
+		}
+		return
+	}
 
-	tprintln(w, "server\t# iters\tspeed (Mbit/s)")
+	if watch > 0 {
+		h := &health{interval: watch}
+		var m *metrics
+		if metricsOut {
+			m = &metrics{exemplars: exemplars}
+		}
+		if listen != "" {
+			serveHealth(listen, h, m)
+		}
+		var roll *rolling
+		if rollWindow > 0 {
+			roll = newRolling(rollWindow)
+		}
+		lastIP := c.Client.IP
+		// The first cycle runs immediately so /healthz and /metrics have
+		// something to report right away, unless told to hold off.
+		if noImmediate {
+			time.Sleep(watch)
+		}
+		for {
+			res, err := run(c, rc)
+			h.set(err)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			} else {
+				emit(res)
+				if m != nil {
+					m.set(res)
+				}
+				if roll != nil {
+					roll.add(time.Now(), res.Mean)
+					fmt.Fprintf(os.Stderr, "rolling %v: p50 %.3f, p90 %.3f, p99 %.3f Mbit/s (%d samples)\n",
+						rollWindow, roll.percentile(50), roll.percentile(90), roll.percentile(99), roll.n())
+					if m != nil {
+						m.setRolling(roll.percentile(50), roll.percentile(90), roll.percentile(99), roll.n())
+					}
+				}
+			}
+			time.Sleep(watch)
+			if !checkIP {
+				continue
+			}
+			// Re-fetch the config so IP changes (and the target churn
+			// that comes with them) are caught: results straddling a
+			// reassignment are not comparable.
+			nc, err := loadConfig()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "config refresh failed:", err)
+				continue
+			}
+			if !nc.Client.IP.Equal(lastIP) {
+				fmt.Fprintf(os.Stderr, "public IP changed from %s to %s; comparisons across this point are suspect\n",
+					lastIP, nc.Client.IP)
+				lastIP = nc.Client.IP
+				if roll != nil {
+					// Percentiles spanning two different paths would
+					// mislead; start the window over.
+					roll.reset()
+					if m != nil {
+						m.setRolling(0, 0, 0, 0)
+					}
+				}
+			}
+			c = nc
+		}
+	}
+
+	if bestOf > 1 {
+		// The peak of several full runs answers "what can this link do",
+		// where the usual single run answers "what is it doing now".
+		var best *Result
+		means := make([]float64, 0, bestOf)
+		for i := 0; i < bestOf; i++ {
+			res, err := run(c, rc)
+			if err != nil {
+				log.Fatal(err)
+			}
+			logf(levelInfo, "run %d of %d: %.3f Mbit/s\n", i+1, bestOf, res.Mean)
+			means = append(means, res.Mean)
+			if best == nil || res.Mean > best.Mean {
+				best = res
+			}
+		}
+		best.BestOfMbps = means
+		emit(best)
+		checkMaxLatency(best)
+		return
+	}
+
+	res, err := run(c, rc)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if margin > 0 {
+		means := []float64{res.Mean}
+		for len(means) < maxCIRuns {
+			half := ciHalfWidth(means, confidence)
+			if len(means) >= 2 && half <= margin*stats.Mean(means, nil) {
+				break
+			}
+			res, err = run(c, rc)
+			if err != nil {
+				log.Fatal(err)
+			}
+			means = append(means, res.Mean)
+		}
+		fmt.Fprintf(os.Stderr, "%d runs; mean of runs %.3f ±%.3f Mbit/s (%g%% CI)\n",
+			len(means), stats.Mean(means, nil), ciHalfWidth(means, confidence), confidence*100)
+	}
+
+	emit(res)
+	checkMaxLatency(res)
+}
 
+// runConfig collects the knobs that shape a single measurement run.
+type runConfig struct {
+	latency bool
+	raw     bool
+	jsonOut bool
+	chatty  bool
+	timeout time.Duration
+	label   string
+	// runID overrides the random per-run UUID, for correlation with an
+	// outer orchestration system.
+	runID string
+	// rateLimit caps download speed at this many Mbit/s; 0 means no cap.
+	// ratePerWorker applies the cap to each worker instead of the whole run.
+	rateLimit     float64
+	ratePerWorker bool
+	// bidi runs upload workers concurrently with the download so both
+	// directions are measured under simultaneous load.
+	bidi bool
+	// interleave instead alternates: download for half the window, then
+	// upload for the other half, reusing the warmed connections. Each
+	// direction is measured in isolation; their sum approximates what a
+	// full-duplex link can carry.
+	interleave bool
+	// detectThrottling samples each target's throughput and flags a
+	// burst-then-throttle pattern; throttleFactor tunes the detector.
+	detectThrottling bool
+	throttleFactor   float64
+	// preconnect warms connections to every target before timing begins,
+	// keeping handshake costs out of short measurement windows.
+	preconnect bool
+	// requestSize switches to many small ranged requests of this size;
+	// see measureOpts.requestSize. rangeOffset starts them mid-file; see
+	// measureOpts.rangeOffset.
+	requestSize int64
+	rangeOffset int64
+	// rejectOutliers drops targets outside the Tukey fences from the
+	// summary statistics.
+	rejectOutliers bool
+	// sparkline prints a small throughput sparkline per target.
+	sparkline bool
+	// fullFile downloads each target's file exactly once, start to finish.
+	fullFile bool
+	// bufferSize is each worker's drain buffer size in bytes.
+	bufferSize int
+	// window overrides each target's measurement window; 0 means the
+	// engine's default.
+	window time.Duration
+	// adaptMin and adaptMax bound the adaptive window when adaptMax is
+	// nonzero; see measureOpts.
+	adaptMin, adaptMax time.Duration
+	// minIters guarantees at least this many completed downloads per
+	// target before the throughput is computed; 0 imposes no minimum.
+	minIters int64
+	// spread measures all targets as one pool, each request pulling the
+	// next URL round-robin, instead of measuring them one at a time.
+	spread bool
+	// ramp reports each target's slow-start ramp time — how long it took
+	// to first sustain 90% of its peak throughput. Implies sampling.
+	ramp bool
+	// timings, if non-nil, receives a CSV row per completed request.
+	timings *timingsLog
+	// folded, if non-nil, accumulates per-phase request timings for the
+	// flamegraph-folded output.
+	folded *foldedLog
+	// checksums maps target URLs to expected sha256 hex digests; full-file
+	// downloads are hashed and compared, and a mismatch fails the target.
+	checksums map[string]string
+	// onTarget, if non-nil, is called with each target's result as soon as
+	// that target finishes, for streaming output formats.
+	onTarget func(*TargetResult)
+	// header holds extra headers for measurement requests; see
+	// measureOpts.header.
+	header http.Header
+	// maxTime is a hard ceiling on any single target's measurement, a
+	// safety cap so one slow target (a full-file download especially)
+	// cannot run for minutes. Whatever transferred by then still counts.
+	maxTime time.Duration
+	// weighting picks how targets are weighted in the summary mean and
+	// stddev: "iters" favors targets that completed more downloads (the
+	// long-standing default), "bytes" weighs by data moved, and "none"
+	// treats every target equally.
+	weighting string
+	// strict makes the first target error cancel the whole run, aborting
+	// every in-flight request. By default failed targets are dropped from
+	// the summary and the run carries on with whatever succeeded.
+	strict bool
+	// parallelism is how many targets are measured at once. The default 1
+	// measures sequentially, keeping per-target numbers comparable; higher
+	// values shorten many-target runs at the cost of targets competing for
+	// the link. Concurrent targets cannot share the dial counter, so Conns
+	// goes unreported then.
+	parallelism int
+}
+
+// run measures every target in c once and returns the aggregate result.
+func run(c *api.Config, rc *runConfig) (*Result, error) {
+	ctx := context.Background()
+	if rc.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rc.timeout)
+		defer cancel()
+	}
+
+	res := &Result{
+		Client: c.Client,
+		Label:  rc.label,
+		RunID:  rc.runID,
+		Start:  time.Now(),
+	}
+	if res.RunID == "" {
+		res.RunID = newRunID()
+	}
+	if rc.chatty {
+		fmt.Fprintf(os.Stderr, "run id: %s\n", res.RunID)
+	}
+	if rc.preconnect {
+		urls := make([]string, len(c.Targets))
+		for i, t := range c.Targets {
+			urls[i] = t.URL
+		}
+		d := preconnect(urls)
+		res.PreconnectMS = ms(d)
+		if rc.chatty {
+			fmt.Fprintf(os.Stderr, "pre-connected to %d targets in %v\n", len(urls), d)
+		}
+		// Timing starts after priming.
+		res.Start = time.Now()
+	}
+	if rc.spread {
+		tr, err := rc.measureSpread(ctx, c.Targets)
+		if err != nil {
+			return nil, err
+		}
+		if tr.CutShort {
+			fmt.Fprintf(os.Stderr, "measurement cut short by %s\n", tr.cutBy)
+		}
+		res.Targets = append(res.Targets, tr)
+		if rc.onTarget != nil {
+			rc.onTarget(&res.Targets[0])
+		}
+		res.Mean = tr.Mbps
+		res.End = time.Now()
+		return res, nil
+	}
 	x := make([]float64, 0, len(c.Targets))
 	weights := make([]float64, 0, len(c.Targets))
+	cutShort := make(map[string][]string)
+	par := rc.parallelism
+	if par < 1 {
+		par = 1
+	}
+	// In strict mode the first target error cancels mctx, aborting every
+	// in-flight request immediately; otherwise failed targets are warned
+	// about and left out of the summary.
+	mctx, abort := context.WithCancel(ctx)
+	defer abort()
+	var (
+		mu       sync.Mutex
+		firstErr error
+		lastErr  error
+		aborter  string
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, par)
 	for i, t := range c.Targets {
-		url := t.URL
-		tprintf(w, "%s", parseHost(url))
-
-		r := testing.Benchmark(func(b *testing.B) {
-			var once sync.Once
-			b.RunParallel(func(pb *testing.PB) {
-				for pb.Next() {
-					resp, err := http.DefaultClient.Get(url)
-					if err != nil {
-						b.Fatal(err)
-					}
-					nw, err := io.Copy(ioutil.Discard, resp.Body)
-					resp.Body.Close()
-					if err != nil {
-						b.Fatal(err)
+		sem <- struct{}{}
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			<-sem
+			break
+		}
+		if budgetExceeded() {
+			<-sem
+			fmt.Fprintf(os.Stderr, "data budget of %d bytes exhausted; skipping the remaining %d target(s)\n",
+				budgetBytes, len(c.Targets)-i)
+			break
+		}
+		wg.Add(1)
+		go func(t api.Target, remaining int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			tr, err := rc.measureTarget(mctx, t, remaining)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if !rc.strict {
+					lastErr = err
+					if len(c.Targets) > 1 {
+						fmt.Fprintf(os.Stderr, "%s: %v; continuing without it\n", parseHost(t.URL), err)
 					}
-					once.Do(func() { b.SetBytes(nw) })
+					return
+				}
+				if firstErr == nil {
+					firstErr = err
+					aborter = parseHost(t.URL)
+					abort()
 				}
-			})
-		})
-		mbps := float64(r.Bytes*int64(r.N)*8) / 1e6 / r.T.Seconds()
-		x = append(x, mbps)
-		weights = append(weights, float64(r.N))
+				return
+			}
+			if tr.CutShort {
+				cutShort[tr.cutBy] = append(cutShort[tr.cutBy], tr.Host)
+			}
+			x = append(x, tr.Mbps)
+			weights = append(weights, targetWeight(rc.weighting, &tr))
+			res.Targets = append(res.Targets, tr)
+			if rc.onTarget != nil {
+				rc.onTarget(&res.Targets[len(res.Targets)-1])
+			}
+		}(t, len(c.Targets)-i)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, fmt.Errorf("-strict: %s: %v; cancelled the rest of the run", aborter, firstErr)
+	}
+	if len(res.Targets) == 0 {
+		if len(c.Targets) == 1 {
+			return nil, lastErr
+		}
+		return nil, fmt.Errorf("all %d targets failed, last error: %v", len(c.Targets), lastErr)
+	}
+	for _, limit := range []string{"-timeout", "-max-time"} {
+		if hosts := cutShort[limit]; len(hosts) > 0 {
+			fmt.Fprintf(os.Stderr, "targets cut short by %s: %s\n", limit, strings.Join(hosts, ", "))
+		}
+	}
+	if rc.rejectOutliers {
+		if out := outliers(x); len(out) > 0 {
+			keepX := make([]float64, 0, len(x)-len(out))
+			keepW := make([]float64, 0, len(x)-len(out))
+			drop := make(map[int]bool, len(out))
+			for _, i := range out {
+				drop[i] = true
+			}
+			for i := range x {
+				if !drop[i] {
+					keepX = append(keepX, x[i])
+					keepW = append(keepW, weights[i])
+				}
+			}
+			x, weights = keepX, keepW
+			res.OutliersRejected = len(out)
+			fmt.Fprintf(os.Stderr, "rejected %d outlier target(s) from the summary\n", len(out))
+		}
+	}
+	res.Mean, res.Stddev = stats.MeanStdDev(x, weights)
+	if par > 1 {
+		res.AggregateMbps = aggregateMbps(res.Targets)
+	}
+	res.End = time.Now()
+	return res, nil
+}
+
+// cutShortBy names the limit that truncated a target's window: -max-time
+// when the per-target cap expired while the run's own share of the deadline
+// (split) was still live, otherwise -timeout. Both limits cancel the same
+// context, so the engine cannot tell them apart on its own.
+func (rc *runConfig) cutShortBy(split context.Context) string {
+	if rc.maxTime > 0 && split.Err() == nil {
+		return "-max-time"
+	}
+	return "-timeout"
+}
+
+// targetWeight returns tr's weight in the summary statistics under the
+// given -weight scheme: completed iterations by default, bytes moved for
+// "bytes", and equal weight for "none".
+func targetWeight(scheme string, tr *TargetResult) float64 {
+	switch scheme {
+	case "bytes":
+		return float64(tr.Bytes)
+	case "none":
+		return 1
+	default:
+		return float64(tr.Iters)
+	}
+}
+
+// aggregateMbps computes the combined throughput of targets that ran
+// concurrently: total bytes over the shared wall-clock window. Summing the
+// per-target means would overstate the link — each mean is bytes over that
+// target's own window, and the windows overlap.
+func aggregateMbps(targets []TargetResult) float64 {
+	if len(targets) == 0 {
+		return 0
+	}
+	var (
+		bytes      int64
+		start, end time.Time
+	)
+	for i, t := range targets {
+		bytes += t.Bytes
+		if i == 0 || t.Start.Before(start) {
+			start = t.Start
+		}
+		if t.End.After(end) {
+			end = t.End
+		}
+	}
+	wall := end.Sub(start)
+	if wall <= 0 {
+		return 0
+	}
+	return float64(bytes) * 8 / 1e6 / wall.Seconds()
+}
+
+// measureSpread measures every target as a single pool: each request pulls
+// the next URL round-robin, so load spreads evenly and no one server can
+// bottleneck the aggregate. The result is one combined row; the per-host
+// request distribution is reported in verbose mode.
+func (rc *runConfig) measureSpread(ctx context.Context, targets []api.Target) (TargetResult, error) {
+	mo := new(measureOpts)
+	if rc.raw {
+		mo.onIter = rawPrinter("spread", rc.jsonOut)
+	}
+	if rc.timings != nil {
+		mo.onRequest = rc.timings.record
+	}
+	if rc.folded != nil {
+		mo.onPhases = rc.folded.record
+	}
+	if rc.rateLimit > 0 {
+		mo.limiter = newLimiterFactory(rc.rateLimit, rc.ratePerWorker)
+	}
+	if rc.detectThrottling || rc.sparkline || rc.ramp {
+		mo.sampleEvery = sampleInterval
+	}
+	mo.requestSize = rc.requestSize
+	mo.rangeOffset = rc.rangeOffset
+	mo.bufferSize = rc.bufferSize
+	mo.window = rc.window
+	mo.adaptMin, mo.adaptMax = rc.adaptMin, rc.adaptMax
+	mo.header = rc.header
+	mo.minIters = rc.minIters
+
+	var (
+		mu   sync.Mutex
+		next int
+		dist = make(map[string]int, len(targets))
+	)
+	mo.pickURL = func() string {
+		mu.Lock()
+		u := targets[next%len(targets)].URL
+		next++
+		dist[parseHost(u)]++
+		mu.Unlock()
+		return u
+	}
+
+	tctx, cancel := splitDeadline(ctx, 1)
+	split := tctx
+	if rc.maxTime > 0 {
+		inner, icancel := context.WithTimeout(tctx, rc.maxTime)
+		outer := cancel
+		tctx, cancel = inner, func() { icancel(); outer() }
+	}
+	defer cancel()
+	tr, err := measure(tctx, targets[0].URL, mo)
+	if err != nil {
+		return tr, err
+	}
+	if tr.CutShort {
+		tr.cutBy = rc.cutShortBy(split)
+	}
+	tr.Host = fmt.Sprintf("%d targets (spread)", len(targets))
+	tr.FinalURL = ""
+	if verbose(levelInfo) {
+		hosts := make([]string, 0, len(dist))
+		for h := range dist {
+			hosts = append(hosts, h)
+		}
+		sort.Strings(hosts)
+		for _, h := range hosts {
+			logf(levelInfo, "%s: %d requests\n", h, dist[h])
+		}
+	}
+	return tr, nil
+}
+
+// measureTarget measures a single target end to end: the optional latency
+// probe, the download (with a concurrent upload in bidi mode), and the
+// per-target diagnostics. remaining is how many targets, this one
+// included, still need a share of ctx's deadline.
+func (rc *runConfig) measureTarget(ctx context.Context, t api.Target, remaining int) (TargetResult, error) {
+	var lat *Latency
+	if rc.latency {
+		// Probe before the download so the path is unloaded.
+		l, err := probeLatency(t.URL, latencyProbes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "latency probe for %s failed: %v\n", parseHost(t.URL), err)
+		} else {
+			lat = &l
+		}
+	}
+	mo := new(measureOpts)
+	if rc.raw {
+		mo.onIter = rawPrinter(t.URL, rc.jsonOut)
+	}
+	if rc.timings != nil {
+		mo.onRequest = rc.timings.record
+	}
+	if rc.folded != nil {
+		mo.onPhases = rc.folded.record
+	}
+	if rc.rateLimit > 0 {
+		mo.limiter = newLimiterFactory(rc.rateLimit, rc.ratePerWorker)
+	}
+	if rc.detectThrottling || rc.sparkline || rc.ramp {
+		mo.sampleEvery = sampleInterval
+	}
+	mo.requestSize = rc.requestSize
+	mo.rangeOffset = rc.rangeOffset
+	mo.bufferSize = rc.bufferSize
+	mo.window = rc.window
+	mo.adaptMin, mo.adaptMax = rc.adaptMin, rc.adaptMax
+	mo.header = rc.header
+	mo.minIters = rc.minIters
+	tctx, cancel := splitDeadline(ctx, remaining)
+	split := tctx
+	if rc.maxTime > 0 {
+		inner, icancel := context.WithTimeout(tctx, rc.maxTime)
+		outer := cancel
+		tctx, cancel = inner, func() { icancel(); outer() }
+	}
+	defer cancel()
+	conns0 := takeConnCount()
+	var (
+		up    TargetResult
+		upErr error
+		done  chan struct{}
+	)
+	if rc.bidi {
+		// Start the upload alongside the download with the same window
+		// and headers, so both directions load the link for the same
+		// span; a default-window upload under a longer -window would
+		// leave the download's tail unloaded.
+		done = make(chan struct{})
+		go func() {
+			up, upErr = measureUpload(tctx, t.UploadTarget(), &measureOpts{window: rc.window, header: rc.header})
+			close(done)
+		}()
+	}
+	var (
+		tr  TargetResult
+		err error
+		sum hash.Hash
+	)
+	switch {
+	case rc.fullFile:
+		if rc.checksums != nil {
+			sum = sha256.New()
+		}
+		tr, err = measureFull(tctx, t.URL, sum)
+	case rc.interleave:
+		half := rc.window
+		if half == 0 {
+			half = defaultWindow
+		}
+		half /= 2
+		mo.window = half
+		tr, err = measure(tctx, t.URL, mo)
+		if err == nil {
+			// Same client, so the upload reuses the connections the
+			// download just warmed; alternating keeps the directions
+			// from contending with each other.
+			up, upErr := measureUpload(tctx, t.UploadTarget(), &measureOpts{window: half, header: rc.header})
+			if upErr != nil {
+				fmt.Fprintf(os.Stderr, "upload to %s failed: %v\n", tr.Host, upErr)
+			} else {
+				tr.UploadMbps = up.Mbps
+				logf(levelInfo, "%s: down %.3f + up %.3f = %.3f Mbit/s combined\n",
+					tr.Host, tr.Mbps, up.Mbps, tr.Mbps+up.Mbps)
+			}
+		}
+	default:
+		tr, err = measure(tctx, t.URL, mo)
+	}
+	if done != nil {
+		<-done
+	}
+	if err != nil {
+		return tr, err
+	}
+	if tr.CutShort {
+		tr.cutBy = rc.cutShortBy(split)
+	}
+	if rc.parallelism <= 1 {
+		// The dial counter is process-wide; with targets running
+		// concurrently a before/after snapshot would attribute other
+		// targets' dials to this one, so Conns is only reported for
+		// sequential runs.
+		tr.Conns = int(takeConnCount() - conns0)
+		logf(levelDebug, "%s: opened %d connections\n", tr.Host, tr.Conns)
+	}
+	if rc.adaptMax > 0 {
+		logf(levelInfo, "%s: adaptive window closed after %v\n",
+			tr.Host, tr.End.Sub(tr.Start).Round(10*time.Millisecond))
+	}
+	if len(tr.Responses) > 0 {
+		keys := make([]string, 0, len(tr.Responses))
+		for k := range tr.Responses {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = fmt.Sprintf("%s: %d", k, tr.Responses[k])
+		}
+		logf(levelDebug, "%s: responses: %s\n", tr.Host, strings.Join(parts, ", "))
+	}
+	if rc.minIters > 0 && int64(tr.Iters) < rc.minIters {
+		fmt.Fprintf(os.Stderr, "%s: only %d of the %d requested iterations completed before the deadline\n",
+			tr.Host, tr.Iters, rc.minIters)
+	}
+	if tr.RangeHonored != nil && !*tr.RangeHonored {
+		fmt.Fprintf(os.Stderr, "%s: server ignored the Range header (200, not 206)\n", tr.Host)
+	}
+	if tr.ContentEncoding != "" {
+		logf(levelDebug, "%s: server forced Content-Encoding %q; throughput counts wire bytes\n",
+			tr.Host, tr.ContentEncoding)
+	}
+	if tr.GoodputMbps > 0 {
+		logf(levelInfo, "%s: wire %.3f Mbit/s, goodput %.3f Mbit/s after decompression\n",
+			tr.Host, tr.Mbps, tr.GoodputMbps)
+	}
+	if rc.fullFile && tr.Bytes >= fullFileMaxBytes {
+		fmt.Fprintf(os.Stderr, "%s: stream did not end; capped at %d bytes\n", tr.Host, tr.Bytes)
+	}
+	if rc.fullFile {
+		logf(levelDebug, "%s: file size %d bytes\n", tr.Host, tr.Bytes)
+		if tr.ContentLengthPresent != nil && !*tr.ContentLengthPresent {
+			logf(levelDebug, "%s: no Content-Length; stream measured by bytes read\n", tr.Host)
+		}
+		if tr.DeclaredBytes > 0 && !tr.CutShort && tr.Bytes != tr.DeclaredBytes && tr.Bytes < fullFileMaxBytes {
+			fmt.Fprintf(os.Stderr, "%s: read %d bytes but Content-Length declared %d\n",
+				tr.Host, tr.Bytes, tr.DeclaredBytes)
+		}
+	}
+	if sum != nil {
+		got := hex.EncodeToString(sum.Sum(nil))
+		tr.SHA256 = got
+		want, ok := rc.checksums[t.URL]
+		switch {
+		case !ok:
+			logf(levelDebug, "%s: no expected checksum for %s\n", tr.Host, t.URL)
+		case tr.CutShort:
+			fmt.Fprintf(os.Stderr, "%s: download truncated by %s; cannot verify\n", tr.Host, tr.cutBy)
+		case got != want:
+			return tr, &checksumError{host: tr.Host, want: want, got: got}
+		default:
+			logf(levelInfo, "%s: checksum verified\n", tr.Host)
+		}
+	}
+	if rc.bidi {
+		if upErr != nil {
+			fmt.Fprintf(os.Stderr, "upload to %s failed: %v\n", tr.Host, upErr)
+		} else {
+			tr.UploadMbps = up.Mbps
+		}
+	}
+	if tr.IterMaxMbps > 0 {
+		logf(levelDebug, "%s: slowest iteration %.3f, fastest %.3f Mbit/s\n",
+			tr.Host, tr.IterMinMbps, tr.IterMaxMbps)
+	}
+	if tr.SetupMS > 0 {
+		logf(levelDebug, "%s: avg connection setup %.1f ms, avg transfer %.1f ms\n",
+			tr.Host, tr.SetupMS, tr.TransferMS)
+	}
+	tr.Latency = lat
+	if tr.TLS != nil {
+		logf(levelDebug, "%s: %s, %s, ALPN %q\n", tr.Host, tr.TLS.Version, tr.TLS.Cipher, tr.TLS.Proto)
+	}
+	if rc.sparkline {
+		if s := sparkline(tr.Samples); s != "" {
+			fmt.Fprintf(os.Stderr, "%s\t%s\n", tr.Host, s)
+		}
+	}
+	if rc.ramp {
+		if d := rampTime(tr.Samples, sampleInterval); d > 0 {
+			tr.RampMS = ms(d)
+			logf(levelInfo, "%s: reached 90%% of peak throughput after %v\n", tr.Host, d)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: window too short to place the ramp\n", tr.Host)
+		}
+	}
+	if rc.detectThrottling {
+		tr.Throttle = detectThrottle(tr.Samples, rc.throttleFactor)
+		if tr.Throttle != nil {
+			fmt.Fprintf(os.Stderr, "%s: possible ISP throttling detected (peak %.3f Mbit/s, plateau %.3f Mbit/s)\n",
+				tr.Host, tr.Throttle.Peak, tr.Throttle.Plateau)
+		}
+	}
+	return tr, nil
+}
+
+func writeMemProfile(name string) {
+	f, err := os.Create(name)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer f.Close()
+	runtime.GC() // materialize up-to-date allocation statistics
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		log.Print(err)
+	}
+}
+
+// multiFlag collects repeated uses of a string flag.
+type multiFlag []string
+
+func (m *multiFlag) String() string { return strings.Join(*m, ",") }
 
-		tprintf(w, "\t%d\t%.3f\n", r.N, mbps)
+func (m *multiFlag) Set(v string) error {
+	*m = append(*m, v)
+	return nil
+}
+
+// newRunID returns a random version 4 UUID.
+func newRunID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand failing is unrecoverable.
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// rawPrinter returns a measure callback that prints one line per completed
+// download, serialized since workers call it concurrently. With -json each
+// line is an independent JSON object; otherwise it is plain text on stderr
+// so it does not pollute the table.
+func rawPrinter(url string, asJSON bool) func(int64, time.Duration) {
+	host := parseHost(url)
+	var mu sync.Mutex
+	return func(n int64, d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		if asJSON {
+			fmt.Printf(`{"type":"iter","host":%q,"bytes":%d,"ms":%.3f}`+"\n", host, n, ms(d))
+		} else {
+			fmt.Fprintf(os.Stderr, "%s\t%d bytes\t%v\n", host, n, d)
+		}
+	}
+}
+
+// Result is the outcome of one full run: each measured target plus the
+// aggregate statistics across all of them.
+type Result struct {
+	Client  api.Client     `json:"client"`
+	Targets []TargetResult `json:"targets"`
+	// Label is a free-form, user-supplied tag (e.g. "wifi-kitchen") for
+	// telling runs under different conditions apart later.
+	Label string `json:"label,omitempty"`
+	// RunID uniquely identifies this run so its rows can be correlated
+	// across formats and storage.
+	RunID string `json:"run_id"`
+	// Mean and Stddev are the iteration-weighted mean and standard
+	// deviation of the per-target speeds, in Mbit/s.
+	Mean   float64 `json:"mean_mbps"`
+	Stddev float64 `json:"stddev_mbps"`
+	// BestOfMbps lists every run's mean when -best-of repeated the whole
+	// measurement; the result carrying it is the fastest of those runs.
+	BestOfMbps []float64 `json:"best_of_mbps,omitempty"`
+	// AggregateMbps is total bytes over the shared wall-clock window, set
+	// only when targets ran concurrently. It is the link's combined
+	// throughput; Mean averages windows that overlap and so cannot be
+	// summed into one.
+	AggregateMbps float64   `json:"aggregate_mbps,omitempty"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	// PreconnectMS is how long the optional connection priming pass took,
+	// in milliseconds; it is excluded from the measured windows.
+	PreconnectMS float64 `json:"preconnect_ms,omitempty"`
+	// OutliersRejected is how many targets were excluded from the summary
+	// statistics as outliers. Their rows are still present in Targets.
+	OutliersRejected int `json:"outliers_rejected,omitempty"`
+}
+
+func (r *Result) hasRPS() bool {
+	for _, t := range r.Targets {
+		if t.RPS != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Result) hasUpload() bool {
+	for _, t := range r.Targets {
+		if t.UploadMbps != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Result) hasLatency() bool {
+	for _, t := range r.Targets {
+		if t.Latency != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// TargetResult is the measurement of a single target.
+type TargetResult struct {
+	Host string `json:"host"`
+	URL  string `json:"url"`
+	// FinalURL is where the bytes actually came from when the server
+	// redirected away from URL. Host reflects it.
+	FinalURL string `json:"final_url,omitempty"`
+	Iters    int    `json:"iters"`
+	// Bytes is the total number of bytes transferred during the window.
+	Bytes int64 `json:"bytes,omitempty"`
+	// Mbps is the measured download speed in Mbit/s.
+	Mbps float64 `json:"mbps"`
+	// UploadMbps is the upload speed measured concurrently with the
+	// download in -bidirectional mode, in Mbit/s.
+	UploadMbps float64 `json:"upload_mbps,omitempty"`
+	// RPS is completed requests per second, reported in -request-size
+	// mode where request overhead, not bandwidth, is under test.
+	RPS float64 `json:"rps,omitempty"`
+	// IterMinMbps and IterMaxMbps are the slowest and fastest single
+	// completed download, in Mbit/s. A wide spread means a bursty link;
+	// note each covers one worker's share, not the whole pipe.
+	IterMinMbps float64 `json:"iter_min_mbps,omitempty"`
+	IterMaxMbps float64 `json:"iter_max_mbps,omitempty"`
+	// SetupMS is the average dial + handshake cost per fresh connection,
+	// and TransferMS the average time an iteration spent moving bytes.
+	// When setup rivals transfer, the window is too short for the number
+	// to mean much.
+	SetupMS    float64 `json:"setup_ms,omitempty"`
+	TransferMS float64 `json:"transfer_ms,omitempty"`
+	// Latency is the target's unloaded latency, if it was probed.
+	Latency *Latency `json:"latency,omitempty"`
+	// TLS holds the negotiated TLS parameters, absent for plaintext.
+	TLS *TLSInfo `json:"tls,omitempty"`
+	// Conns is how many TCP connections were opened while measuring this
+	// target, uploads included in -bidirectional mode. More connections
+	// than workers means keep-alive reuse did not happen. Absent when
+	// -target-parallelism measured targets concurrently: the counter is
+	// process-wide and cannot attribute those dials per target.
+	Conns int `json:"conns,omitempty"`
+	// Responses tallies requests by HTTP status code, plus "timeout" and
+	// "error" buckets for requests that never got one. Intermittent server
+	// trouble shows up here long before it moves the mean.
+	Responses map[string]int `json:"responses,omitempty"`
+	// RangeHonored reports whether the server answered ranged requests
+	// with 206 Partial Content rather than ignoring the Range header and
+	// streaming from the start. Only set in ranged modes.
+	RangeHonored *bool `json:"range_honored,omitempty"`
+	// ContentLengthPresent reports whether the server declared a
+	// Content-Length; servers that stream without one behave differently
+	// in the byte-budgeted modes. DeclaredBytes is the declared length
+	// when present.
+	ContentLengthPresent *bool `json:"content_length_present,omitempty"`
+	DeclaredBytes        int64 `json:"declared_bytes,omitempty"`
+	// SHA256 is the hex digest of a -verify'd full-file download.
+	SHA256 string `json:"sha256,omitempty"`
+	// GoodputMbps is the post-decompression (application-level) rate,
+	// set only when the server compressed the body and the two layers
+	// therefore diverge. Mbps always counts wire bytes.
+	GoodputMbps float64 `json:"goodput_mbps,omitempty"`
+	// ContentEncoding is set when the server compressed the body anyway
+	// (e.g. a forced "br"). Bytes and Mbps always count wire bytes — the
+	// client never asks for compression and never decompresses — so the
+	// decompressed size, if any, is not what was measured.
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	// CutShort records that the measurement window was truncated by a
+	// deadline outside the window itself: the run's -timeout or the
+	// per-target -max-time cap.
+	CutShort bool `json:"cut_short,omitempty"`
+	// cutBy names the flag whose limit did the truncating, for the
+	// user-facing messages. Empty when CutShort is false.
+	cutBy string
+	// Start and End bound this target's measurement window, for lining
+	// results up with external events.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Samples is the instantaneous throughput time series, in Mbit/s,
+	// when sampling was enabled.
+	Samples []float64 `json:"samples_mbps,omitempty"`
+	// RampMS is how long the connection took to first sustain 90% of its
+	// peak throughput (TCP slow start, mostly), when -ramp asked for it.
+	RampMS float64 `json:"ramp_ms,omitempty"`
+	// Throttle is set when the time series shows a burst-then-throttle
+	// pattern.
+	Throttle *Throttle `json:"throttle,omitempty"`
+}
+
+// sortTargets orders the per-target rows before rendering; every output
+// format sees the same order. The empty mode preserves API order.
+func sortTargets(res *Result, mode string) {
+	t := res.Targets
+	switch mode {
+	case "host":
+		sort.SliceStable(t, func(i, j int) bool { return t[i].Host < t[j].Host })
+	case "speed-asc":
+		sort.SliceStable(t, func(i, j int) bool { return t[i].Mbps < t[j].Mbps })
+	case "speed-desc":
+		sort.SliceStable(t, func(i, j int) bool { return t[i].Mbps > t[j].Mbps })
+	case "latency":
+		// Targets that were not probed sort last.
+		key := func(tr TargetResult) time.Duration {
+			if tr.Latency == nil {
+				return time.Duration(1<<63 - 1)
+			}
+			return tr.Latency.Min
+		}
+		sort.SliceStable(t, func(i, j int) bool { return key(t[i]) < key(t[j]) })
+	}
+}
+
+// tableMaxWidth caps the width of the host and ISP columns; longer values
+// are truncated with an ellipsis so the table stays aligned. Zero means no
+// limit. Structured outputs always carry the full values.
+var tableMaxWidth int
+
+// tableShortNames swaps full CDN hostnames for their distinguishing part in
+// the table. Structured outputs always carry the full values.
+var tableShortNames bool
+
+// shortName extracts the part of a CDN hostname that actually tells the
+// targets apart. fast.com hosts look like
+// "ipv4-c056-lax009-ix.1.oca.nflxvideo.net": everything but the first label
+// is shared boilerplate, and the family prefix repeats the -4/-6 choice.
+// Hostnames that do not match that shape pass through unchanged.
+func shortName(host string) string {
+	name := host
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		name = name[:i]
+	}
+	name = strings.TrimPrefix(name, "ipv4-")
+	name = strings.TrimPrefix(name, "ipv6-")
+	if name == "" {
+		return host
+	}
+	return name
+}
+
+// truncate shortens s to at most max runes, ending in an ellipsis. A max
+// of zero or less means no limit.
+func truncate(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-1]) + "…"
+}
+
+func writeTable(out io.Writer, res *Result, summary bool) {
+	w := new(tabwriter.Writer)
+	initWriter(w, out)
+
+	header := "server\t# iters\tspeed (Mbit/s)"
+	if res.hasRPS() {
+		header += "\treq/s"
+	}
+	if res.hasUpload() {
+		header += "\tupload (Mbit/s)"
+	}
+	if res.hasLatency() {
+		header += "\tlatency (ms)"
+	}
+	tprintln(w, header)
+	for i, t := range res.Targets {
+		name := t.Host
+		if tableShortNames {
+			name = shortName(name)
+		}
+		tprintf(w, "%s\t%d\t%.3f", truncate(name, tableMaxWidth), t.Iters, t.Mbps)
+		if res.hasRPS() {
+			tprintf(w, "\t%.1f", t.RPS)
+		}
+		if res.hasUpload() {
+			tprintf(w, "\t%.3f", t.UploadMbps)
+		}
+		if t.Latency != nil {
+			tprintf(w, "\t%.1f", ms(t.Latency.Min))
+		}
+		tprintf(w, "\n")
 		// Align the "RESULT: ..." section. This only works because all the URLs
 		// are the same size.
-		if i != len(c.Targets)-1 {
+		if i != len(res.Targets)-1 {
 			w.Flush()
-			initWriter(w)
+			initWriter(w, out)
+		}
+	}
+	if summary {
+		tprintf(w, "\t\t%.3f ±%.3f\n", res.Mean, res.Stddev)
+		if res.AggregateMbps > 0 {
+			tprintf(w, "\t\t%.3f combined\n", res.AggregateMbps)
 		}
 	}
-
-	mean, std := stat.MeanStdDev(x, weights)
-	tprintf(w, "\t\t%.3f ±%.3f\n", mean, std)
 	w.Flush()
 }
 
-func initWriter(w *tabwriter.Writer) {
-	w.Init(os.Stdout, 20, 1, 3, ' ', tabwriter.StripEscape)
+// tableMinWidth and tablePadding are the tabwriter's column floor and
+// inter-column gap. The defaults suit a normal-width terminal; -table-width
+// and -table-padding (or a narrow $COLUMNS) adjust them.
+var (
+	tableMinWidth = 20
+	tablePadding  = 3
+)
+
+func initWriter(w *tabwriter.Writer, out io.Writer) {
+	w.Init(out, tableMinWidth, 1, tablePadding, ' ', tabwriter.StripEscape)
 }
 
 func tprintf(w *tabwriter.Writer, format string, args ...interface{}) {
@@ -109,6 +2008,178 @@ func tprintln(w *tabwriter.Writer, args ...interface{}) {
 	fmt.Fprintln(w, args...)
 }
 
+// failFastTimeout is how long the -fail-fast probe waits for a TCP
+// connection before declaring the network unreachable.
+const failFastTimeout = 3 * time.Second
+
+// checkFirstTarget dials the first target's host directly with a short
+// timeout, so a dead network is reported in seconds instead of after every
+// target times out in turn. Only the dial is probed — a connection-level
+// failure on the nearest CDN node almost always means "network down", where
+// an HTTP-level error would only mean "that server is unhappy".
+func checkFirstTarget(t api.Target) error {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "https":
+			host = net.JoinHostPort(host, "443")
+		default:
+			host = net.JoinHostPort(host, "80")
+		}
+	}
+	conn, err := net.DialTimeout("tcp", host, failFastTimeout)
+	if err != nil {
+		return fmt.Errorf("fail-fast: cannot connect to %s: %v (is the network down?)", host, err)
+	}
+	conn.Close()
+	return nil
+}
+
+// preferLargeFiles reorders targets so files of at least minSize bytes come
+// first, keeping API order within each group. A file that is too small
+// finishes before slow start does and under-reports fast links; the engine
+// loops small files back-to-back, but a file that sustains the whole window
+// is still the better measurement. Sizes come from parallel HEAD requests;
+// targets that refuse HEAD count as size unknown and sort last.
+func preferLargeFiles(targets []api.Target, minSize int64) {
+	type sized struct {
+		t    api.Target
+		size int64
+	}
+	rows := make([]sized, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		rows[i] = sized{t: t, size: -1}
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			resp, err := httpClient.Head(url)
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			rows[i].size = resp.ContentLength
+		}(i, t.URL)
+	}
+	wg.Wait()
+
+	var largest int64 = -1
+	for _, r := range rows {
+		logf(levelDebug, "%s: file size %d bytes\n", parseHost(r.t.URL), r.size)
+		if r.size > largest {
+			largest = r.size
+		}
+	}
+	if largest >= 0 && largest < minSize {
+		fmt.Fprintf(os.Stderr, "no target advertises ≥%d bytes (largest is %d); small files are looped back-to-back, but fast links may still under-report\n",
+			minSize, largest)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].size >= minSize && rows[j].size < minSize
+	})
+	for i, r := range rows {
+		targets[i] = r.t
+	}
+}
+
+// matchTargets returns the targets whose hostname matches re, preserving
+// API order. Matching is against the hostname alone, not the whole URL, so
+// patterns name POPs (e.g. "lax|sjc") without fighting URL syntax.
+func matchTargets(targets []api.Target, re *regexp.Regexp) []api.Target {
+	kept := make([]api.Target, 0, len(targets))
+	for _, t := range targets {
+		if re.MatchString(parseHost(t.URL)) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// sortByDistance orders targets by great-circle distance from the client,
+// nearest first, since nearer servers usually sustain higher throughput.
+// Targets without coordinates sort last; without client coordinates this is
+// a no-op, because there is nothing to measure distance from.
+func sortByDistance(c *api.Config) {
+	if !c.Client.Location.HasCoords() {
+		fmt.Fprintln(os.Stderr, "the API gave no client coordinates; -prefer-near has nothing to sort by")
+		return
+	}
+	clat, clon := c.Client.Location.Latitude, c.Client.Location.Longitude
+	dist := func(t api.Target) float64 {
+		if !t.Location.HasCoords() {
+			return math.Inf(1)
+		}
+		return distanceKM(clat, clon, t.Location.Latitude, t.Location.Longitude)
+	}
+	sort.SliceStable(c.Targets, func(i, j int) bool {
+		return dist(c.Targets[i]) < dist(c.Targets[j])
+	})
+	for _, t := range c.Targets {
+		if d := dist(t); math.IsInf(d, 1) {
+			logf(levelInfo, "%s: distance unknown\n", parseHost(t.URL))
+		} else {
+			logf(levelInfo, "%s: ~%.0f km away\n", parseHost(t.URL), d)
+		}
+	}
+}
+
+// distanceKM is the haversine great-circle distance between two points, in
+// kilometers. GeoIP coordinates are rough, so nothing finer is warranted.
+func distanceKM(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKM = 6371
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dlat := rad(lat2 - lat1)
+	dlon := rad(lon2 - lon1)
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * earthRadiusKM * math.Asin(math.Sqrt(a))
+}
+
+// loadConfigFile reads an api.Config from a JSON file in the same shape as
+// the api.fast.com v2 response: {"client": {...}, "targets": [{"url": ...}]}.
+// Only targets[].url is required. This skips the API entirely, which is
+// useful for air-gapped smoke tests against local or mirror servers.
+func loadConfigFile(name string) (*api.Config, error) {
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var c api.Config
+	if err := json.Unmarshal(buf, &c); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return &c, nil
+}
+
+// readTokenFile parses a file of API tokens, one per line, so large token
+// pools stay out of the command line and environment. Blank lines and
+// #-comments are skipped.
+func readTokenFile(name string) ([]string, error) {
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []string
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: no tokens", name)
+	}
+	return tokens, nil
+}
+
 func parseHost(url_ string) string {
 	u, err := url.Parse(url_)
 	if err != nil {
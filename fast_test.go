@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ericlagergren/fast/internal/stats"
+)
+
+// TestWeightingSchemes pins what each -weight scheme computes on a known
+// dataset: two targets at 100 and 200 Mbit/s, where the slower one
+// completed more iterations and the faster one moved more bytes.
+func TestWeightingSchemes(t *testing.T) {
+	targets := []TargetResult{
+		{Mbps: 100, Iters: 3, Bytes: 40e6},
+		{Mbps: 200, Iters: 1, Bytes: 120e6},
+	}
+	for _, tt := range []struct {
+		scheme string
+		mean   float64
+	}{
+		// iters: (100*3 + 200*1) / 4
+		{"iters", 125},
+		// bytes: (100*40e6 + 200*120e6) / 160e6
+		{"bytes", 175},
+		// none: (100 + 200) / 2
+		{"none", 150},
+	} {
+		x := make([]float64, len(targets))
+		w := make([]float64, len(targets))
+		for i := range targets {
+			x[i] = targets[i].Mbps
+			w[i] = targetWeight(tt.scheme, &targets[i])
+		}
+		mean := stats.Mean(x, w)
+		if math.Abs(mean-tt.mean) > 1e-9 {
+			t.Errorf("%s: mean = %g, want %g", tt.scheme, mean, tt.mean)
+		}
+	}
+}
+
+// TestTargetWeightDefault checks unknown schemes fall back to iteration
+// weighting, the long-standing default.
+func TestTargetWeightDefault(t *testing.T) {
+	tr := TargetResult{Iters: 7, Bytes: 9}
+	if w := targetWeight("iters", &tr); w != 7 {
+		t.Errorf("iters weight = %g, want 7", w)
+	}
+	if w := targetWeight("", &tr); w != 7 {
+		t.Errorf("default weight = %g, want 7", w)
+	}
+}
+
+// TestMain lets the test binary stand in for the real one: with FAST_ARGS
+// set it runs main with those arguments instead of the tests, which is how
+// the flag-validation tests below observe log.Fatal exits without killing
+// the test process.
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv("FAST_ARGS"); ok {
+		os.Args = append([]string{"fast"}, strings.Fields(args)...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// TestFlagValidation walks the conflict/requires matrix: every bad
+// combination must exit non-zero before any work happens, with an error
+// naming the flags involved.
+func TestFlagValidation(t *testing.T) {
+	for _, tt := range []struct {
+		args string
+		want string
+	}{
+		{"-json -influx", "-json and -influx are mutually exclusive"},
+		{"-json -ndjson", "-json and -ndjson are mutually exclusive"},
+		{"-4 -6", "both -4 and -6 given"},
+		{"-46 -4", "-46 and -4 are mutually exclusive"},
+		{"-exemplars", "-exemplars requires -metrics"},
+		{"-metrics", "-metrics requires -listen"},
+		{"-append", "-append requires -output"},
+		{"-rolling 1h", "-rolling requires -watch"},
+		{"-verify sums.txt", "-verify requires -full-file"},
+		{"-stdin -watch 1m", "-stdin and -watch are mutually exclusive"},
+		{"-folded out.folded -watch 1m", "-folded and -watch are mutually exclusive"},
+	} {
+		cmd := exec.Command(os.Args[0])
+		cmd.Env = append(os.Environ(), "FAST_ARGS="+tt.args)
+		out, err := cmd.CombinedOutput()
+		ee, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Errorf("%s: exited %v, want a validation failure", tt.args, err)
+			continue
+		}
+		if ee.ExitCode() == 0 {
+			t.Errorf("%s: exited 0, want non-zero", tt.args)
+		}
+		if !strings.Contains(string(out), tt.want) {
+			t.Errorf("%s: output %q does not mention %q", tt.args, out, tt.want)
+		}
+	}
+}
+
+// TestAggregateMbps checks the concurrent aggregate is total bytes over the
+// shared wall-clock window, not the sum of the per-target means — the two
+// differ as soon as the windows only partially overlap.
+func TestAggregateMbps(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	targets := []TargetResult{
+		// 125e6 bytes over 0s-10s: 100 Mbit/s on its own.
+		{Bytes: 125e6, Start: t0, End: t0.Add(10 * time.Second), Mbps: 100},
+		// 125e6 bytes over 5s-15s: also 100 Mbit/s on its own.
+		{Bytes: 125e6, Start: t0.Add(5 * time.Second), End: t0.Add(15 * time.Second), Mbps: 100},
+	}
+	// 250e6 bytes over the 15s the pair spanned, not 100+100.
+	want := 250e6 * 8 / 1e6 / 15
+	if got := aggregateMbps(targets); math.Abs(got-want) > 1e-9 {
+		t.Errorf("aggregateMbps = %g, want %g", got, want)
+	}
+
+	// Fully overlapping windows: the aggregate equals the naive sum only
+	// in this special case.
+	targets[1].Start, targets[1].End = t0, t0.Add(10*time.Second)
+	if got := aggregateMbps(targets); math.Abs(got-200) > 1e-9 {
+		t.Errorf("aggregateMbps = %g, want 200", got)
+	}
+
+	if got := aggregateMbps(nil); got != 0 {
+		t.Errorf("aggregateMbps(nil) = %g, want 0", got)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// writeFields prints one space-separated line per target with no header and
+// no alignment padding, for awk and cut. The field order is a stable
+// interface:
+//
+//	host mbps iters
+//
+// New fields, should any ever be added, will be appended to the end, never
+// inserted, so $1..$3 keep meaning the same thing across versions.
+func writeFields(out io.Writer, res *Result) {
+	for _, t := range res.Targets {
+		// Whitespace in a field would shift everything after it; the
+		// synthetic spread "host" contains spaces, so squash them.
+		host := strings.Join(strings.Fields(t.Host), "_")
+		fmt.Fprintf(out, "%s %.3f %d\n", host, t.Mbps, t.Iters)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The phases of a request, in stack order. They are disjoint so the values
+// add up: ttfb here is first-byte latency with the setup phases subtracted
+// back out, and transfer is everything after the first byte.
+const (
+	phaseDNS = iota
+	phaseConnect
+	phaseTLS
+	phaseTTFB
+	phaseTransfer
+	numPhases
+)
+
+var phaseNames = [numPhases]string{"dns", "connect", "tls", "ttfb", "transfer"}
+
+// foldedLog aggregates per-request phase timings and writes them in the
+// "folded" format flamegraph tools eat — one "host;phase value" line per
+// phase, values in milliseconds summed across every request. Across a long
+// run it answers "where did the time actually go" at a glance.
+type foldedLog struct {
+	mu     sync.Mutex
+	f      *os.File
+	totals map[string]*[numPhases]time.Duration
+}
+
+func openFoldedLog(name string) (*foldedLog, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &foldedLog{f: f, totals: make(map[string]*[numPhases]time.Duration)}, nil
+}
+
+// record folds one request's phases into the running totals. Safe for
+// concurrent use.
+func (l *foldedLog) record(host string, phases [numPhases]time.Duration) {
+	l.mu.Lock()
+	t := l.totals[host]
+	if t == nil {
+		t = new([numPhases]time.Duration)
+		l.totals[host] = t
+	}
+	for i, d := range phases {
+		t[i] += d
+	}
+	l.mu.Unlock()
+}
+
+// Close writes the aggregated folded lines and closes the file.
+func (l *foldedLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hosts := make([]string, 0, len(l.totals))
+	for h := range l.totals {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	for _, h := range hosts {
+		for i, d := range l.totals[h] {
+			if d <= 0 {
+				continue
+			}
+			if _, err := fmt.Fprintf(l.f, "%s;%s %d\n", h, phaseNames[i], d.Milliseconds()); err != nil {
+				l.f.Close()
+				return err
+			}
+		}
+	}
+	return l.f.Close()
+}
@@ -3,7 +3,7 @@ module github.com/ericlagergren/fast
 go 1.13
 
 require (
-	github.com/gonum/integrate v0.0.0-20181209220457-a422b5c0fdf2 // indirect
-	github.com/gonum/stat v0.0.0-20181125101827-41a0da705a5b
-	golang.org/x/perf v0.0.0-20190823172224-ecb187b06eb0 // indirect
+	github.com/lucas-clemente/quic-go v0.14.4
+	golang.org/x/net v0.0.0-20190827160401-ba9fcec4b297
+	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4
 )
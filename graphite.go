@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// writeGraphite prints res in the Graphite plaintext protocol — one
+// "path value timestamp" line per metric — suitable for piping straight to
+// a Carbon socket. prefix roots every metric path; timestamps are Unix
+// seconds, which is all Carbon accepts.
+func writeGraphite(out io.Writer, res *Result, prefix string, summary bool) {
+	ts := res.End.Unix()
+	for _, t := range res.Targets {
+		base := prefix + "." + graphiteSegment(t.Host)
+		fmt.Fprintf(out, "%s.download %f %d\n", base, t.Mbps, ts)
+		if t.UploadMbps != 0 {
+			fmt.Fprintf(out, "%s.upload %f %d\n", base, t.UploadMbps, ts)
+		}
+		if t.Latency != nil {
+			fmt.Fprintf(out, "%s.latency %f %d\n", base, ms(t.Latency.Min), ts)
+		}
+	}
+	if summary {
+		fmt.Fprintf(out, "%s.download %f %d\n", prefix, res.Mean, ts)
+		fmt.Fprintf(out, "%s.download_stddev %f %d\n", prefix, res.Stddev, ts)
+	}
+}
+
+// graphiteSegment makes s safe as a single metric path segment: dots would
+// create spurious hierarchy and spaces break the line protocol, so anything
+// outside Graphite's comfortable character set becomes an underscore.
+func graphiteSegment(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
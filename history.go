@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ericlagergren/fast/internal/stats"
+)
+
+// appendHistory records res as one JSON line in the history file, the same
+// shape as -json output, so accumulated runs can be post-processed with jq
+// or summarized later with -report.
+func appendHistory(name string, res *Result) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(res); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// reportHistory reads the JSON-lines history at name and prints the runs
+// grouped by ISP and ASN: how many runs each network has, the average and
+// spread of their means, and the span of time they cover. Lines that do not
+// parse are skipped so one corrupt record cannot hide the rest.
+func reportHistory(out io.Writer, name string) error {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no history at %s; record runs with -history first", name)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	type group struct {
+		isp, asn    string
+		means       []float64
+		first, last time.Time
+	}
+	groups := make(map[string]*group)
+	var skipped int
+	sc := bufio.NewScanner(f)
+	// Results with many targets produce long lines; the default 64K cap
+	// would reject them.
+	sc.Buffer(make([]byte, 0, 64<<10), 4<<20)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var res Result
+		if err := json.Unmarshal(sc.Bytes(), &res); err != nil {
+			skipped++
+			continue
+		}
+		key := res.Client.ISP + "\x00" + res.Client.ASN
+		g := groups[key]
+		if g == nil {
+			g = &group{isp: res.Client.ISP, asn: res.Client.ASN, first: res.Start}
+			groups[key] = g
+		}
+		g.means = append(g.means, res.Mean)
+		if res.Start.Before(g.first) {
+			g.first = res.Start
+		}
+		if res.Start.After(g.last) {
+			g.last = res.Start
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if len(groups) == 0 {
+		return fmt.Errorf("%s holds no runs", name)
+	}
+
+	rows := make([]*group, 0, len(groups))
+	for _, g := range groups {
+		rows = append(rows, g)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if len(rows[i].means) != len(rows[j].means) {
+			return len(rows[i].means) > len(rows[j].means)
+		}
+		return rows[i].isp < rows[j].isp
+	})
+
+	w := new(tabwriter.Writer)
+	initWriter(w, out)
+	tprintln(w, "isp\tasn\truns\tavg (Mbit/s)\tfirst\tlast")
+	for _, g := range rows {
+		isp := g.isp
+		if isp == "" {
+			isp = "???"
+		}
+		avg := stats.Mean(g.means, nil)
+		if len(g.means) >= 2 {
+			_, std := stats.MeanStdDev(g.means, nil)
+			tprintf(w, "%s\t%s\t%d\t%.3f ±%.3f", truncate(isp, tableMaxWidth), g.asn, len(g.means), avg, std)
+		} else {
+			tprintf(w, "%s\t%s\t%d\t%.3f", truncate(isp, tableMaxWidth), g.asn, len(g.means), avg)
+		}
+		tprintf(w, "\t%s\t%s\n", g.first.Format("2006-01-02"), g.last.Format("2006-01-02"))
+	}
+	w.Flush()
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d unparsable history line(s)\n", skipped)
+	}
+	return nil
+}
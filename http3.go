@@ -0,0 +1,18 @@
+//go:build http3
+// +build http3
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/lucas-clemente/quic-go/http3"
+)
+
+// http3Client returns a client that speaks HTTP/3 over QUIC. It is only
+// compiled with the http3 build tag so the default build does not drag in
+// quic-go; the experimental RoundTripper has no TCP fallback, so targets
+// without HTTP/3 support simply fail.
+func http3Client() (*http.Client, error) {
+	return &http.Client{Transport: &http3.RoundTripper{}}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !http3
+// +build !http3
+
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// http3Client reports that this binary lacks HTTP/3 support; see http3.go.
+func http3Client() (*http.Client, error) {
+	return nil, errors.New("built without HTTP/3 support; rebuild with -tags http3")
+}
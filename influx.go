@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// writeInflux prints res in InfluxDB line protocol, one point per target
+// followed, when summary is set, by an aggregate point. Suitable for
+// telegraf's exec plugin.
+func writeInflux(out io.Writer, res *Result, summary bool) {
+	ts := res.End.UnixNano()
+	tags := influxTags(res)
+	for _, t := range res.Targets {
+		fmt.Fprintf(out, "fast,host=%s%s download=%f,iters=%di",
+			escapeInfluxTag(t.Host), tags, t.Mbps, t.Iters)
+		if t.UploadMbps != 0 {
+			fmt.Fprintf(out, ",upload=%f", t.UploadMbps)
+		}
+		if t.Latency != nil {
+			fmt.Fprintf(out, ",latency=%f,latency_mean=%f,latency_max=%f",
+				ms(t.Latency.Min), ms(t.Latency.Mean), ms(t.Latency.Max))
+		}
+		fmt.Fprintf(out, " %d\n", ts)
+	}
+	if summary {
+		fmt.Fprintf(out, "fast%s download=%f,download_stddev=%f,run_id=%q %d\n",
+			tags, res.Mean, res.Stddev, res.RunID, ts)
+	}
+}
+
+// influxTags renders the client's ISP and ASN as a tag set fragment,
+// skipping empty values since the line protocol forbids empty tags.
+func influxTags(res *Result) string {
+	var b strings.Builder
+	if isp := res.Client.ISP; isp != "" {
+		b.WriteString(",isp=")
+		b.WriteString(escapeInfluxTag(isp))
+	}
+	if asn := res.Client.ASN; asn != "" {
+		b.WriteString(",asn=")
+		b.WriteString(escapeInfluxTag(asn))
+	}
+	if res.Label != "" {
+		b.WriteString(",label=")
+		b.WriteString(escapeInfluxTag(res.Label))
+	}
+	return b.String()
+}
+
+var influxTagEscaper = strings.NewReplacer(
+	",", `\,`,
+	"=", `\=`,
+	" ", `\ `,
+)
+
+// escapeInfluxTag escapes a tag key or value per the line protocol spec.
+func escapeInfluxTag(s string) string {
+	return influxTagEscaper.Replace(s)
+}
@@ -2,21 +2,27 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"runtime"
 	"strconv"
+	"strings"
 )
 
 type loader struct {
 	c         *http.Client
 	token     string
+	tokens    []string
 	nurls     int
 	userAgent string
+	onTarget  func(Target)
 }
 
 // Option is a function that modifies the loading of an API configuration.
@@ -32,6 +38,12 @@ func WithToken(token string) Option {
 	return func(c *loader) { c.token = token }
 }
 
+// WithTokens sets multiple API tokens. Load tries them in order, rotating
+// to the next when the API rejects one as invalid or rate limited.
+func WithTokens(tokens ...string) Option {
+	return func(c *loader) { c.tokens = tokens }
+}
+
 // NumURLs sets the number of URLs the configuration should contain.
 func NumURLs(n int) Option {
 	return func(c *loader) { c.nurls = n }
@@ -42,6 +54,15 @@ func WithUserAgent(userAgent string) Option {
 	return func(c *loader) { c.userAgent = userAgent }
 }
 
+// WithTargetFunc sets a function called with each target as it is decoded
+// out of the response stream, before the rest of the configuration has
+// arrived. Callers can use it to start resolving or pre-connecting early;
+// anything slow should be handed off to a goroutine, since fn blocks the
+// decode.
+func WithTargetFunc(fn func(Target)) Option {
+	return func(c *loader) { c.onTarget = fn }
+}
+
 const (
 	// DefaultToken is a known api.fast.com token, used by Load if no other token
 	// is specified.
@@ -50,6 +71,15 @@ const (
 	DefaultUserAgent = "fast/0.1 (" + runtime.GOOS + "; " + runtime.GOARCH + ")"
 )
 
+// maxConfigBytes caps how much of a config response Load will buffer.
+const maxConfigBytes = 1 << 20
+
+// Errors on which Load rotates to the next token, if one is available.
+var (
+	errInvalidToken = errors.New("invalid API token")
+	errRateLimited  = errors.New("rate limited")
+)
+
 // Load fetches api.fast.com's configuration.
 func Load(opts ...Option) (*Config, error) {
 	l := loader{
@@ -65,8 +95,31 @@ func Load(opts ...Option) (*Config, error) {
 	if l.token == "" {
 		l.token = DefaultToken
 	}
+	tokens := l.tokens
+	if len(tokens) == 0 {
+		tokens = []string{l.token}
+	}
+
+	var failures []string
+	for i, token := range tokens {
+		cfg, err := l.fetch(token)
+		if err == nil {
+			cfg.tokenIndex = i
+			return cfg, nil
+		}
+		if err != errInvalidToken && err != errRateLimited {
+			return nil, err
+		}
+		if len(tokens) == 1 {
+			return nil, err
+		}
+		failures = append(failures, fmt.Sprintf("token %d: %v", i, err))
+	}
+	return nil, fmt.Errorf("all %d tokens failed: %s", len(tokens), strings.Join(failures, "; "))
+}
 
-	req, err := http.NewRequest("GET", makeURL(l.token, l.nurls), nil)
+func (l *loader) fetch(token string) (*Config, error) {
+	req, err := http.NewRequest("GET", makeURL(token, l.nurls), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -82,16 +135,116 @@ func Load(opts ...Option) (*Config, error) {
 	case http.StatusOK:
 		// OK
 	case http.StatusForbidden:
-		return nil, errors.New("invalid API token")
+		return nil, errInvalidToken
+	case http.StatusTooManyRequests:
+		return nil, errRateLimited
 	default:
 		return nil, fmt.Errorf("non-200 status code: %d", c)
 	}
 
-	var cfg Config
-	err = json.NewDecoder(resp.Body).Decode(&cfg)
+	// A well-formed config is a few KB; refuse to buffer an unbounded
+	// stream from a misbehaving endpoint.
+	limited := io.LimitReader(resp.Body, maxConfigBytes+1)
+
+	// With an onTarget callback, decode the stream incrementally so each
+	// target is handed out the moment it parses, instead of only after
+	// the whole response has arrived. Without one there is nothing to
+	// gain, so take the simple buffered path.
+	if l.onTarget != nil {
+		cfg, err := l.decodeStream(limited)
+		if err != nil {
+			return nil, err
+		}
+		cfg.requested = l.nurls
+		return cfg, nil
+	}
+
+	body, err := ioutil.ReadAll(limited)
 	if err != nil {
 		return nil, err
 	}
+	if len(body) > maxConfigBytes {
+		return nil, fmt.Errorf("config response exceeds %d bytes", maxConfigBytes)
+	}
+	var cfg Config
+	if err := json.Unmarshal(body, &cfg); err != nil {
+		return nil, err
+	}
+	cfg.raw = body
+	cfg.requested = l.nurls
+	return &cfg, nil
+}
+
+// decodeStream decodes a config object incrementally, invoking onTarget per
+// decoded target. The stream is teed into a buffer so Raw() still returns
+// the response byte for byte.
+func (l *loader) decodeStream(r io.Reader) (*Config, error) {
+	var buf bytes.Buffer
+	dec := json.NewDecoder(io.TeeReader(r, &buf))
+
+	// The size cap truncates r, so an oversized response surfaces as a
+	// JSON error mid-stream; report the cap, not the confusing EOF.
+	fail := func(err error) error {
+		if buf.Len() > maxConfigBytes {
+			return fmt.Errorf("config response exceeds %d bytes", maxConfigBytes)
+		}
+		return err
+	}
+
+	var cfg Config
+	if tok, err := dec.Token(); err != nil {
+		return nil, fail(err)
+	} else if tok != json.Delim('{') {
+		return nil, fmt.Errorf("config is %v, not an object", tok)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fail(err)
+		}
+		switch tok {
+		case "client":
+			if err := dec.Decode(&cfg.Client); err != nil {
+				return nil, fail(err)
+			}
+		case "targets":
+			if tok, err := dec.Token(); err != nil {
+				return nil, fail(err)
+			} else if tok != json.Delim('[') {
+				return nil, fmt.Errorf("targets is %v, not an array", tok)
+			}
+			for dec.More() {
+				var t Target
+				if err := dec.Decode(&t); err != nil {
+					return nil, fail(err)
+				}
+				cfg.Targets = append(cfg.Targets, t)
+				l.onTarget(t)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, fail(err)
+			}
+		default:
+			// Fields this version does not parse still count toward raw.
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, fail(err)
+			}
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fail(err)
+	}
+	// Drain whatever trails the object (normally nothing) so raw is the
+	// complete response — the tee already captured everything the decoder
+	// consumed — then apply the same size cap as the buffered path.
+	if _, err := io.Copy(&buf, r); err != nil {
+		return nil, fail(err)
+	}
+	if buf.Len() > maxConfigBytes {
+		return nil, fmt.Errorf("config response exceeds %d bytes", maxConfigBytes)
+	}
+	cfg.raw = buf.Bytes()
 	return &cfg, nil
 }
 
@@ -114,11 +267,72 @@ type Config struct {
 	Client Client `json:"client"`
 	// Targets contains
 	Targets []Target `json:"targets"`
+
+	raw        []byte
+	requested  int
+	tokenIndex int
+}
+
+// Raw returns the configuration exactly as the API returned it, byte for
+// byte, suitable for saving as an inspectable fixture. It is nil for
+// configurations that did not come from Load.
+func (c *Config) Raw() []byte { return c.raw }
+
+// UsedToken returns the index of the token that successfully loaded the
+// configuration, when several were supplied via WithTokens.
+func (c *Config) UsedToken() int {
+	return c.tokenIndex
+}
+
+// RequestedURLs returns the number of URLs the configuration was asked to
+// contain, which the API may under-deliver. Compare with len(c.Targets).
+func (c *Config) RequestedURLs() int {
+	return c.requested
+}
+
+// Validate reports whether c is usable for measurement. It is intended for
+// configurations injected from outside Load, e.g. read from a file.
+func (c *Config) Validate() error {
+	if len(c.Targets) == 0 {
+		return errors.New("api: config has no targets")
+	}
+	for i, t := range c.Targets {
+		u, err := url.Parse(t.URL)
+		if err != nil {
+			return fmt.Errorf("api: target %d: %v", i, err)
+		}
+		if !u.IsAbs() || u.Host == "" {
+			return fmt.Errorf("api: target %d: URL %q is not absolute", i, t.URL)
+		}
+	}
+	return nil
 }
 
 type Location struct {
 	Country string `json:"country"`
 	City    string `json:"city"`
+	// Latitude and Longitude are the location's approximate coordinates,
+	// when the API provides them. Both zero means absent.
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+}
+
+// String formats the location as a searchable "City, Country" string,
+// omitting whichever parts are absent.
+func (l Location) String() string {
+	switch {
+	case l.City != "" && l.Country != "":
+		return l.City + ", " + l.Country
+	case l.City != "":
+		return l.City
+	default:
+		return l.Country
+	}
+}
+
+// HasCoords reports whether the API provided coordinates for the location.
+func (l Location) HasCoords() bool {
+	return l.Latitude != 0 || l.Longitude != 0
 }
 
 // Client contains information on the client requesting the configuration.
@@ -143,4 +357,20 @@ type Target struct {
 	Location Location `json:"location"`
 	// Name is currently set to the same thing as URL.
 	Name string `json:"name"`
+	// UploadURL is a distinct endpoint for upload measurement, should the
+	// API ever return one. It is currently absent from responses, in which
+	// case uploads POST to URL.
+	UploadURL string `json:"uploadUrl,omitempty"`
+	// MaxUploadBytes is the largest upload payload the target accepts,
+	// when the API states one. Zero means no stated limit.
+	MaxUploadBytes int64 `json:"maxUploadBytes,omitempty"`
+}
+
+// UploadTarget returns the URL uploads should POST to: UploadURL when the
+// API provided one, otherwise the download URL.
+func (t *Target) UploadTarget() string {
+	if t.UploadURL != "" {
+		return t.UploadURL
+	}
+	return t.URL
 }
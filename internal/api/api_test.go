@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// configFixture is a representative v2 response, including the
+// upload-specific target fields the API may start returning.
+const configFixture = `{
+	"client": {
+		"asn": "209",
+		"isp": "CenturyLink",
+		"location": {"country": "US", "city": "Denver"},
+		"ip": "203.0.113.7"
+	},
+	"targets": [
+		{
+			"url": "https://example.net/speedtest/range/0-26214400",
+			"name": "https://example.net/speedtest/range/0-26214400",
+			"location": {"country": "US", "city": "Denver"},
+			"uploadUrl": "https://example.net/speedtest/upload",
+			"maxUploadBytes": 26214400
+		},
+		{
+			"url": "https://example.org/speedtest/range/0-26214400",
+			"name": "https://example.org/speedtest/range/0-26214400",
+			"location": {"country": "US", "city": "Boulder"}
+		}
+	]
+}`
+
+func TestTargetUploadFields(t *testing.T) {
+	var cfg Config
+	if err := json.Unmarshal([]byte(configFixture), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Targets) != 2 {
+		t.Fatalf("got %d targets, want 2", len(cfg.Targets))
+	}
+
+	// The first target states a distinct upload endpoint and a cap.
+	got := cfg.Targets[0]
+	if want := "https://example.net/speedtest/upload"; got.UploadURL != want {
+		t.Errorf("UploadURL = %q, want %q", got.UploadURL, want)
+	}
+	if want := int64(26214400); got.MaxUploadBytes != want {
+		t.Errorf("MaxUploadBytes = %d, want %d", got.MaxUploadBytes, want)
+	}
+	if want := "https://example.net/speedtest/upload"; got.UploadTarget() != want {
+		t.Errorf("UploadTarget() = %q, want %q", got.UploadTarget(), want)
+	}
+
+	// The second has no upload fields, so uploads fall back to the
+	// download URL.
+	got = cfg.Targets[1]
+	if got.UploadURL != "" || got.MaxUploadBytes != 0 {
+		t.Errorf("unexpected upload fields: %q, %d", got.UploadURL, got.MaxUploadBytes)
+	}
+	if got.UploadTarget() != got.URL {
+		t.Errorf("UploadTarget() = %q, want the download URL %q", got.UploadTarget(), got.URL)
+	}
+}
+
+// stubTransport answers every request with a canned body, so Load can be
+// exercised without the network.
+type stubTransport struct {
+	body  string
+	calls int
+}
+
+func (s *stubTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	s.calls++
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(s.body)),
+	}, nil
+}
+
+// TestLoadUsesClient checks the client handed to WithClient is the one Load
+// actually fetches through, which is what lets main share one connection
+// pool between the config fetch and the measurement.
+func TestLoadUsesClient(t *testing.T) {
+	st := &stubTransport{body: configFixture}
+	cfg, err := Load(WithClient(&http.Client{Transport: st}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.calls != 1 {
+		t.Errorf("the supplied client served %d requests, want 1", st.calls)
+	}
+	if len(cfg.Targets) != 2 {
+		t.Errorf("got %d targets, want 2", len(cfg.Targets))
+	}
+}
+
+// oversizedConfig is a syntactically valid config padded past
+// maxConfigBytes, the way a buggy or malicious endpoint might stream.
+func oversizedConfig() string {
+	return `{"client":{},"targets":[{"url":"https://example.net/x"}],"pad":"` +
+		strings.Repeat("x", maxConfigBytes) + `"}`
+}
+
+// TestLoadRejectsOversizedConfig checks both decode paths refuse to buffer
+// an unbounded response body instead of exhausting memory on it.
+func TestLoadRejectsOversizedConfig(t *testing.T) {
+	// The buffered path.
+	st := &stubTransport{body: oversizedConfig()}
+	if _, err := Load(WithClient(&http.Client{Transport: st})); err == nil {
+		t.Error("buffered Load accepted an oversized config")
+	} else if !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("buffered Load: err = %v, want a size-cap error", err)
+	}
+
+	// The streaming path used when a target callback is installed.
+	st = &stubTransport{body: oversizedConfig()}
+	_, err := Load(
+		WithClient(&http.Client{Transport: st}),
+		WithTargetFunc(func(Target) {}),
+	)
+	if err == nil {
+		t.Error("streaming Load accepted an oversized config")
+	} else if !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("streaming Load: err = %v, want a size-cap error", err)
+	}
+}
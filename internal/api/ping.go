@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// PingResult summarizes the round trips of one Ping call. Min is the
+// primary number: it best represents the unloaded path, while Mean and Max
+// mostly reflect queueing and scheduling noise.
+type PingResult struct {
+	Min  time.Duration
+	Mean time.Duration
+	Max  time.Duration
+}
+
+// Ping estimates t's unloaded latency with n tiny HTTP round trips,
+// requesting a single byte each time so the transfer is negligible next to
+// the RTT. It needs nothing from the measurement machinery, so callers that
+// only want latency — server selection, health checks — can use it on its
+// own. A nil hc uses http.DefaultClient; n < 1 is treated as one probe.
+func Ping(ctx context.Context, t Target, n int, hc *http.Client) (PingResult, error) {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	if n < 1 {
+		n = 1
+	}
+	var r PingResult
+	var total time.Duration
+	for i := 0; i < n; i++ {
+		req, err := http.NewRequest("GET", t.URL, nil)
+		if err != nil {
+			return r, err
+		}
+		req.Header.Set("Range", "bytes=0-0")
+		start := time.Now()
+		resp, err := hc.Do(req.WithContext(ctx))
+		if err != nil {
+			return r, err
+		}
+		// Drain just enough to reuse the connection; a server that
+		// ignores the Range header must not turn the probe into a
+		// download.
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<10))
+		resp.Body.Close()
+		d := time.Since(start)
+		if i == 0 || d < r.Min {
+			r.Min = d
+		}
+		if d > r.Max {
+			r.Max = d
+		}
+		total += d
+	}
+	r.Mean = total / time.Duration(n)
+	return r, nil
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestPing checks the summary against a server with a known injected delay:
+// every probe takes at least the delay, and Min ≤ Mean ≤ Max.
+func TestPing(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Write([]byte{0})
+	}))
+	defer srv.Close()
+
+	r, err := Ping(context.Background(), Target{URL: srv.URL}, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Min < delay {
+		t.Errorf("Min = %v, want >= %v", r.Min, delay)
+	}
+	if r.Min > r.Mean || r.Mean > r.Max {
+		t.Errorf("want Min <= Mean <= Max, got %v, %v, %v", r.Min, r.Mean, r.Max)
+	}
+}
+
+// TestPingBoundedDrain serves a huge body from a server that ignores the
+// Range header and checks Ping still returns promptly — the probe must not
+// become a download.
+func TestPingBoundedDrain(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 1<<20)
+		for i := 0; i < 64; i++ {
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := Ping(ctx, Target{URL: srv.URL}, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestPingClampsProbeCount treats n < 1 as a single probe rather than
+// returning zeros or dividing by zero.
+func TestPingClampsProbeCount(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0})
+	}))
+	defer srv.Close()
+
+	r, err := Ping(context.Background(), Target{URL: srv.URL}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Min <= 0 || r.Mean <= 0 {
+		t.Errorf("n=0 gave Min %v, Mean %v; want one real probe", r.Min, r.Mean)
+	}
+}
@@ -0,0 +1,183 @@
+// Package stats provides the few statistical routines the CLI needs:
+// weighted means and standard deviations, empirical quantiles, and Student's
+// t quantiles. It replaces gonum/stat, which pulled a full scientific
+// computing stack into the build for a handful of formulas; the definitions
+// here match gonum's, so the reported numbers do not change.
+package stats
+
+import "math"
+
+// Mean returns the weighted arithmetic mean of x. A nil weights slice means
+// every value counts equally; otherwise weights must be the same length as x.
+func Mean(x, weights []float64) float64 {
+	if weights == nil {
+		var sum float64
+		for _, v := range x {
+			sum += v
+		}
+		return sum / float64(len(x))
+	}
+	if len(x) != len(weights) {
+		panic("stats: slice length mismatch")
+	}
+	var sum, sumWeights float64
+	for i, v := range x {
+		sum += v * weights[i]
+		sumWeights += weights[i]
+	}
+	return sum / sumWeights
+}
+
+// MeanStdDev returns the weighted mean and the unbiased weighted sample
+// standard deviation of x, with the same conventions as Mean.
+func MeanStdDev(x, weights []float64) (mean, std float64) {
+	mean = Mean(x, weights)
+	// Two passes with compensation, so near-equal values do not lose their
+	// tiny differences to cancellation.
+	var ss, comp, sumWeights float64
+	if weights == nil {
+		for _, v := range x {
+			d := v - mean
+			ss += d * d
+			comp += d
+		}
+		sumWeights = float64(len(x))
+	} else {
+		for i, v := range x {
+			d := v - mean
+			w := weights[i]
+			ss += w * d * d
+			comp += w * d
+			sumWeights += w
+		}
+	}
+	variance := (ss - comp*comp/sumWeights) / (sumWeights - 1)
+	return mean, math.Sqrt(variance)
+}
+
+// Quantile returns the empirical p-quantile of x, which must be sorted in
+// ascending order: the smallest element whose cumulative fraction of the
+// data reaches p.
+func Quantile(p float64, x []float64) float64 {
+	if p < 0 || p > 1 || len(x) == 0 {
+		panic("stats: invalid quantile input")
+	}
+	i := int(math.Ceil(p*float64(len(x)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return x[i]
+}
+
+// TQuantile returns the p-quantile of Student's t-distribution with nu
+// degrees of freedom, i.e. the value t with P(T ≤ t) = p. It inverts the
+// CDF by bisection, which is plenty fast for the handful of calls the CLI
+// makes per run.
+func TQuantile(p, nu float64) float64 {
+	switch {
+	case p <= 0:
+		return math.Inf(-1)
+	case p >= 1:
+		return math.Inf(1)
+	case p == 0.5:
+		return 0
+	case p < 0.5:
+		return -TQuantile(1-p, nu)
+	}
+	hi := 1.0
+	for tCDF(hi, nu) < p && hi < 1e9 {
+		hi *= 2
+	}
+	lo := 0.0
+	for i := 0; i < 200; i++ {
+		mid := (lo + hi) / 2
+		if mid == lo || mid == hi {
+			break
+		}
+		if tCDF(mid, nu) < p {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// tCDF is the CDF of Student's t-distribution with nu degrees of freedom,
+// expressed through the regularized incomplete beta function.
+func tCDF(t, nu float64) float64 {
+	ib := regIncBeta(nu/2, 0.5, nu/(nu+t*t))
+	if t >= 0 {
+		return 1 - ib/2
+	}
+	return ib / 2
+}
+
+// regIncBeta is the regularized incomplete beta function I_x(a, b),
+// evaluated by the standard continued fraction (see Numerical Recipes §6.4).
+func regIncBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	lgab, _ := math.Lgamma(a + b)
+	bt := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+	// The continued fraction converges quickly only on one side of the
+	// mean; use the symmetry relation for the other.
+	if x < (a+1)/(a+b+2) {
+		return bt * betacf(a, b, x) / a
+	}
+	return 1 - bt*betacf(b, a, 1-x)/b
+}
+
+// betacf evaluates the continued fraction for regIncBeta by the modified
+// Lentz method.
+func betacf(a, b, x float64) float64 {
+	const (
+		maxIter = 200
+		eps     = 3e-14
+		fpmin   = 1e-300
+	)
+	qab, qap, qam := a+b, a+1, a-1
+	c := 1.0
+	d := 1 - qab*x/qap
+	if math.Abs(d) < fpmin {
+		d = fpmin
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= maxIter; m++ {
+		m2 := float64(2 * m)
+		aa := float64(m) * (b - float64(m)) * x / ((qam + m2) * (a + m2))
+		d = 1 + aa*d
+		if math.Abs(d) < fpmin {
+			d = fpmin
+		}
+		c = 1 + aa/c
+		if math.Abs(c) < fpmin {
+			c = fpmin
+		}
+		d = 1 / d
+		h *= d * c
+		aa = -(a + float64(m)) * (qab + float64(m)) * x / ((a + m2) * (qap + m2))
+		d = 1 + aa*d
+		if math.Abs(d) < fpmin {
+			d = fpmin
+		}
+		c = 1 + aa/c
+		if math.Abs(c) < fpmin {
+			c = fpmin
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < eps {
+			break
+		}
+	}
+	return h
+}
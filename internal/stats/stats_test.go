@@ -0,0 +1,92 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMean(t *testing.T) {
+	if got := Mean([]float64{1, 2, 3, 4}, nil); got != 2.5 {
+		t.Errorf("Mean = %g, want 2.5", got)
+	}
+	// Weighted: (1*1 + 2*2 + 3*3) / (1 + 2 + 3).
+	if got, want := Mean([]float64{1, 2, 3}, []float64{1, 2, 3}), 14.0/6; math.Abs(got-want) > 1e-12 {
+		t.Errorf("weighted Mean = %g, want %g", got, want)
+	}
+}
+
+func TestMeanStdDev(t *testing.T) {
+	// A classic textbook sample: mean 5, unbiased variance 32/7.
+	x := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+	mean, std := MeanStdDev(x, nil)
+	if mean != 5 {
+		t.Errorf("mean = %g, want 5", mean)
+	}
+	if want := math.Sqrt(32.0 / 7); math.Abs(std-want) > 1e-12 {
+		t.Errorf("stddev = %g, want %g", std, want)
+	}
+
+	// Weighted, matching gonum's unbiased weighted convention:
+	// mean = (1*1 + 3*2) / 4 = 1.75,
+	// variance = (Σw·d² − (Σw·d)²/Σw) / (Σw − 1) = 0.75/3.
+	mean, std = MeanStdDev([]float64{1, 2}, []float64{1, 3})
+	if mean != 1.75 {
+		t.Errorf("weighted mean = %g, want 1.75", mean)
+	}
+	if want := 0.5; math.Abs(std-want) > 1e-12 {
+		t.Errorf("weighted stddev = %g, want %g", std, want)
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	x := []float64{1, 2, 3, 4}
+	for _, tt := range []struct {
+		p    float64
+		want float64
+	}{
+		{0, 1},
+		{0.25, 1},
+		{0.5, 2},
+		{0.75, 3},
+		{0.9, 4},
+		{1, 4},
+	} {
+		if got := Quantile(tt.p, x); got != tt.want {
+			t.Errorf("Quantile(%g) = %g, want %g", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestTQuantile(t *testing.T) {
+	// Reference values from standard t tables.
+	for _, tt := range []struct {
+		p, nu float64
+		want  float64
+	}{
+		{0.975, 1, 12.706205},
+		{0.95, 1, 6.313752},
+		{0.975, 9, 2.262157},
+		{0.95, 10, 1.812461},
+		{0.995, 2, 9.924843},
+		{0.9, 5, 1.475884},
+		{0.975, 100, 1.983972},
+	} {
+		if got := TQuantile(tt.p, tt.nu); math.Abs(got-tt.want) > 1e-5 {
+			t.Errorf("TQuantile(%g, %g) = %g, want %g", tt.p, tt.nu, got, tt.want)
+		}
+	}
+
+	// Symmetry and edge cases.
+	if got := TQuantile(0.5, 7); got != 0 {
+		t.Errorf("TQuantile(0.5, 7) = %g, want 0", got)
+	}
+	if got, want := TQuantile(0.025, 9), -TQuantile(0.975, 9); got != want {
+		t.Errorf("TQuantile(0.025, 9) = %g, want %g", got, want)
+	}
+	if got := TQuantile(0, 3); !math.IsInf(got, -1) {
+		t.Errorf("TQuantile(0, 3) = %g, want -Inf", got)
+	}
+	if got := TQuantile(1, 3); !math.IsInf(got, 1) {
+		t.Errorf("TQuantile(1, 3) = %g, want +Inf", got)
+	}
+}
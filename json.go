@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// writeJSON prints res as a single JSON object: compact by default, which
+// suits piping into jq or another program, or indented when pretty is set.
+// Only the formatting differs; the data is identical either way. With
+// summary off only the per-target array is printed, for consumers doing
+// their own aggregation.
+func writeJSON(out io.Writer, res *Result, pretty, summary bool) error {
+	enc := json.NewEncoder(out)
+	if pretty {
+		enc.SetIndent("", "  ")
+	}
+	if !summary {
+		return enc.Encode(res.Targets)
+	}
+	return enc.Encode(res)
+}
+
+// ndjsonTarget emits one JSON object for a just-finished target, tagged so
+// consumers reading the stream line by line can tell it from the summary.
+func ndjsonTarget(out io.Writer, tr *TargetResult) error {
+	return json.NewEncoder(out).Encode(struct {
+		Type string `json:"type"`
+		*TargetResult
+	}{"target", tr})
+}
+
+// ndjsonSummary emits the run's aggregate as the stream's final object. The
+// per-target rows already went out as they completed, so they are elided
+// here rather than repeated.
+func ndjsonSummary(out io.Writer, res *Result) error {
+	summary := *res
+	summary.Targets = nil
+	return json.NewEncoder(out).Encode(struct {
+		Type string `json:"type"`
+		*Result
+	}{"summary", &summary})
+}
+
+// MarshalJSON reports latencies in fractional milliseconds, which is what
+// every consumer wants, rather than Go's default nanosecond integers.
+func (l Latency) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Min  float64 `json:"min_ms"`
+		Mean float64 `json:"mean_ms"`
+		Max  float64 `json:"max_ms"`
+	}{ms(l.Min), ms(l.Mean), ms(l.Max)})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/ericlagergren/fast/internal/api"
+)
+
+// latencyProbes is the number of round trips used to estimate a target's
+// unloaded latency.
+const latencyProbes = 5
+
+// Latency summarizes round-trip probes against a target, with the same
+// fields and interpretation as api.PingResult: read Min first.
+type Latency struct {
+	Min  time.Duration
+	Mean time.Duration
+	Max  time.Duration
+}
+
+// probeLatency times n tiny round trips against url via api.Ping, using the
+// shared measurement client so probes ride the same path as the transfers.
+func probeLatency(url string, n int) (Latency, error) {
+	r, err := api.Ping(context.Background(), api.Target{URL: url}, n, httpClient)
+	if err != nil {
+		return Latency{}, err
+	}
+	return Latency{Min: r.Min, Mean: r.Mean, Max: r.Max}, nil
+}
+
+// ms converts d to fractional milliseconds for display.
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
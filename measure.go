@@ -0,0 +1,676 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ericlagergren/fast/counter"
+	"golang.org/x/time/rate"
+)
+
+// httpClient is the client used for the config fetch and all measurement
+// traffic, so both phases share one connection pool. main may swap it out
+// (e.g. for a proxied client) before any measurement starts; it must not
+// change afterwards.
+var httpClient = newClient()
+
+// defaultWindow is how long a single target is measured absent a tighter
+// deadline: long enough to get past TCP slow start, short enough that a
+// multi-target run stays snappy.
+const defaultWindow = 5 * time.Second
+
+// measureOpts are the optional knobs for a single target's measurement.
+// The zero value measures flat out with no callbacks.
+type measureOpts struct {
+	// onIter, if non-nil, is called from the worker goroutines with the
+	// size and duration of every completed download, letting callers
+	// stream per-iteration records without measure buffering them.
+	onIter func(bytes int64, d time.Duration)
+	// onRequest, if non-nil, receives every completed request's wall-clock
+	// milestones. Like onIter it is called from the worker goroutines and
+	// must not buffer on measure's behalf.
+	onRequest func(reqTiming)
+	// onPhases, if non-nil, receives every completed request's time split
+	// into the disjoint folded phases (dns, connect, tls, ttfb, transfer).
+	// Collecting them costs a few extra trace callbacks, so it is only
+	// done when asked.
+	onPhases func(host string, phases [numPhases]time.Duration)
+	// limiter, if non-nil, is called once per worker for the rate limiter
+	// that throttles its reads. Returning a shared limiter caps the
+	// aggregate rate; returning fresh ones caps each worker.
+	limiter func() *rate.Limiter
+	// payload supplies upload request bodies; see payloadFactory. Only
+	// measureUpload uses it.
+	payload payloadFactory
+	// sampleEvery, if nonzero, records the instantaneous throughput at
+	// this interval into TargetResult.Samples.
+	sampleEvery time.Duration
+	// requestSize, if nonzero, asks for only this many bytes per request
+	// (via a Range header) instead of streaming the whole file, measuring
+	// request overhead rather than raw bandwidth.
+	requestSize int64
+	// rangeOffset starts ranged requests at this offset instead of 0,
+	// probing mid-file resumption the way a restarted download would.
+	rangeOffset int64
+	// bufferSize is the size of each worker's drain buffer. Larger
+	// buffers mean fewer syscalls, which matters on very fast links.
+	bufferSize int
+	// window overrides defaultWindow, e.g. to keep a many-target scan
+	// bounded. Zero means the default.
+	window time.Duration
+	// adaptMin and adaptMax, when adaptMax is nonzero, replace the fixed
+	// window with an adaptive one: sampling is forced on, and the window
+	// closes as soon as the throughput has settled — no earlier than
+	// adaptMin, no later than adaptMax. Fast links finish quickly; links
+	// still ramping get the time they need.
+	adaptMin, adaptMax time.Duration
+	// minIters, if nonzero, keeps the workers going past the window until
+	// at least this many downloads have completed, so slow links that fit
+	// only an iteration or two in the window still produce a stable
+	// number. The caller's context still bounds the extension.
+	minIters int64
+	// pickURL, if non-nil, supplies each request's URL in place of the
+	// fixed url argument, letting one measurement spread its requests
+	// over several targets.
+	pickURL func() string
+	// header holds extra headers set on every measurement request, for
+	// experimenting with intermediaries that key on them. Note that
+	// "Connection: close" forces a fresh handshake per request, which the
+	// measured number will reflect.
+	header http.Header
+}
+
+// reqTiming is one request's wall-clock milestones, as streamed to
+// measureOpts.onRequest: when it started, how long the first response byte
+// took, how long the whole thing took, and how many bytes it moved.
+type reqTiming struct {
+	url   string
+	start time.Time
+	ttfb  time.Duration // zero if no response byte ever arrived
+	total time.Duration
+	bytes int64
+}
+
+const (
+	// defaultBufferSize is the drain buffer size absent -buffer-size.
+	defaultBufferSize = 256 << 10
+	// minBufferSize is the smallest drain buffer accepted; anything less
+	// measures syscall overhead, not the network.
+	minBufferSize = 4 << 10
+)
+
+// measure downloads url from parallel workers until ctx expires or the
+// measurement window closes, and reports the achieved speed. Bytes from
+// downloads interrupted by the deadline still count toward the total; only
+// fully completed downloads count as iterations.
+//
+// An error is returned only if nothing was transferred at all.
+func measure(ctx context.Context, url string, opts *measureOpts) (TargetResult, error) {
+	if opts == nil {
+		opts = new(measureOpts)
+	}
+	tr := TargetResult{Host: parseHost(url), URL: url}
+
+	window := opts.window
+	if window == 0 {
+		window = defaultWindow
+	}
+	sampleEvery := opts.sampleEvery
+	if opts.adaptMax > 0 {
+		window = opts.adaptMax
+		if sampleEvery == 0 {
+			sampleEvery = sampleInterval
+		}
+	}
+	wctx, cancel := context.WithTimeout(ctx, window)
+	defer cancel()
+
+	// Draining into the counter, rather than trusting each copy's return
+	// value, means bytes from in-flight downloads are accounted the moment
+	// they land — the sampler and cancelled transfers both see them.
+	var (
+		counted counter.BytesCounter
+		// goodput counts application bytes after decompression when the
+		// server compressed the body anyway. counted stays wire bytes —
+		// the headline number — so the two diverge exactly when the
+		// encoding makes "bytes" ambiguous.
+		goodput counter.BytesCounter
+		iters   int64
+
+		mu       sync.Mutex
+		firstErr error
+		// counts tallies responses by status code and failures by kind, the
+		// cheap per-request diagnostic a single throughput number hides.
+		counts = make(map[string]int)
+		// iterMin and iterMax bracket the completed iterations' individual
+		// speeds, exposing how bursty the link is beneath the mean.
+		iterMin, iterMax float64
+		// Setup (dial + TLS) vs. transfer time, accumulated across
+		// iterations. On short windows setup can dominate, and that is
+		// worth knowing before blaming the link.
+		setupTotal    time.Duration
+		setups        int64
+		transferTotal time.Duration
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+	tally := func(key string) {
+		mu.Lock()
+		counts[key]++
+		mu.Unlock()
+	}
+	record := func(bytes int64, d time.Duration) {
+		if d <= 0 {
+			return
+		}
+		m := float64(bytes) * 8 / 1e6 / d.Seconds()
+		mu.Lock()
+		if iterMin == 0 || m < iterMin {
+			iterMin = m
+		}
+		if m > iterMax {
+			iterMax = m
+		}
+		mu.Unlock()
+	}
+
+	// Record connection metadata off the first response: where the bytes
+	// actually came from (with redirects the final URL can differ from the
+	// one the API handed out) and what TLS parameters were negotiated.
+	var (
+		finalOnce sync.Once
+		finalURL  string
+		tlsState  *tls.ConnectionState
+		status    int
+		declared  int64
+		encoding  string
+	)
+
+	var samples []float64
+	sampled := make(chan struct{})
+	if sampleEvery > 0 {
+		go func() {
+			defer close(sampled)
+			tick := time.NewTicker(sampleEvery)
+			defer tick.Stop()
+			var last int64
+			for {
+				select {
+				case <-tick.C:
+					cur := counted.N()
+					samples = append(samples, float64(cur-last)*8/1e6/sampleEvery.Seconds())
+					last = cur
+					// In adaptive mode the sampler doubles as the judge:
+					// once past the minimum and settled, close the window
+					// early. The Done case below ends this goroutine.
+					if opts.adaptMax > 0 &&
+						time.Duration(len(samples))*sampleEvery >= opts.adaptMin &&
+						stableSamples(samples) {
+						cancel()
+					}
+				case <-wctx.Done():
+					return
+				}
+			}
+		}()
+	} else {
+		close(sampled)
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var lim *rate.Limiter
+			if opts.limiter != nil {
+				lim = opts.limiter()
+			}
+			size := opts.bufferSize
+			if size == 0 {
+				size = defaultBufferSize
+			}
+			buf := make([]byte, size)
+			for {
+				if wctx.Err() != nil &&
+					(atomic.LoadInt64(&iters) >= opts.minIters || ctx.Err() != nil) {
+					return
+				}
+				if budgetExceeded() {
+					return
+				}
+				// Requests run inside the window, except extension
+				// iterations owed to minIters, which run on the caller's
+				// context so an outer deadline still caps them.
+				rctx := wctx
+				if wctx.Err() != nil {
+					rctx = ctx
+				}
+				u := url
+				if opts.pickURL != nil {
+					u = opts.pickURL()
+				}
+				req, err := http.NewRequest("GET", u, nil)
+				if err != nil {
+					fail(err)
+					return
+				}
+				if opts.requestSize > 0 {
+					off := opts.rangeOffset
+					req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+opts.requestSize-1))
+				}
+				for k, v := range opts.header {
+					req.Header[k] = v
+				}
+				// Time connection establishment separately from the
+				// transfer. GetConn→GotConn spans dial and handshake for
+				// fresh connections; reused ones cost nothing.
+				t0 := time.Now()
+				var (
+					connStart time.Time
+					setup     time.Duration
+					ttfb      time.Duration
+				)
+				trace := &httptrace.ClientTrace{
+					GetConn: func(string) { connStart = time.Now() },
+					GotConn: func(info httptrace.GotConnInfo) {
+						if !info.Reused {
+							setup = time.Since(connStart)
+						}
+					},
+					GotFirstResponseByte: func() { ttfb = time.Since(t0) },
+				}
+				var phases [numPhases]time.Duration
+				if opts.onPhases != nil {
+					var dnsAt, dialAt, tlsAt time.Time
+					trace.DNSStart = func(httptrace.DNSStartInfo) { dnsAt = time.Now() }
+					trace.DNSDone = func(httptrace.DNSDoneInfo) { phases[phaseDNS] += time.Since(dnsAt) }
+					trace.ConnectStart = func(string, string) { dialAt = time.Now() }
+					trace.ConnectDone = func(string, string, error) { phases[phaseConnect] += time.Since(dialAt) }
+					trace.TLSHandshakeStart = func() { tlsAt = time.Now() }
+					trace.TLSHandshakeDone = func(tls.ConnectionState, error) { phases[phaseTLS] += time.Since(tlsAt) }
+				}
+				resp, err := httpClient.Do(req.WithContext(httptrace.WithClientTrace(rctx, trace)))
+				if err != nil {
+					// Requests cut off by the window closing are not
+					// failures; everything else is worth a bucket.
+					if rctx.Err() == nil {
+						tally(errKey(err))
+						fail(err)
+					}
+					return
+				}
+				tally(strconv.Itoa(resp.StatusCode))
+				finalOnce.Do(func() {
+					finalURL = resp.Request.URL.String()
+					tlsState = resp.TLS
+					status = resp.StatusCode
+					declared = resp.ContentLength
+					encoding = resp.Header.Get("Content-Encoding")
+				})
+				body := io.Reader(resp.Body)
+				if lim != nil {
+					body = &limitedReader{r: body, lim: lim, ctx: rctx}
+				}
+				var n int64
+				if resp.Header.Get("Content-Encoding") == "gzip" {
+					// Count both layers: compressed bytes as they cross
+					// the wire, decompressed bytes as the application
+					// would see them. The -budget meters the wire side of
+					// the tee, so the copy below goes through ctxReader
+					// alone rather than copyContext's budgeted path.
+					var wire counter.BytesCounter
+					var zr *gzip.Reader
+					zr, err = gzip.NewReader(io.TeeReader(&budgetReader{r: body}, io.MultiWriter(&counted, &wire)))
+					if err == nil {
+						_, err = io.CopyBuffer(&goodput, &ctxReader{ctx: rctx, r: zr}, buf)
+						zr.Close()
+					}
+					n = wire.N()
+				} else {
+					n, err = copyContext(rctx, &counted, body, buf)
+				}
+				resp.Body.Close()
+				if err == errBudget {
+					// Not a failure; the bytes that landed still count.
+					return
+				}
+				if err != nil {
+					if rctx.Err() == nil {
+						tally(errKey(err))
+						fail(err)
+					}
+					return
+				}
+				atomic.AddInt64(&iters, 1)
+				d := time.Since(t0)
+				record(n, d)
+				mu.Lock()
+				if setup > 0 {
+					setupTotal += setup
+					setups++
+				}
+				transferTotal += d - setup
+				mu.Unlock()
+				if opts.onIter != nil {
+					opts.onIter(n, d)
+				}
+				if opts.onRequest != nil {
+					opts.onRequest(reqTiming{url: u, start: t0, ttfb: ttfb, total: d, bytes: n})
+				}
+				if opts.onPhases != nil {
+					// Make the phases sum to the total: ttfb minus the
+					// setup pieces is pure server wait, the rest is body.
+					wait := ttfb - phases[phaseDNS] - phases[phaseConnect] - phases[phaseTLS]
+					if wait < 0 {
+						wait = 0
+					}
+					phases[phaseTTFB] = wait
+					phases[phaseTransfer] = d - ttfb
+					opts.onPhases(parseHost(u), phases)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	tr.Start, tr.End = start, start.Add(elapsed)
+	// The workers only exit once wctx is done, so the sampler is wrapping
+	// up too; join it before touching samples.
+	cancel()
+	<-sampled
+	tr.Samples = samples
+	if finalURL != "" && finalURL != url {
+		tr.FinalURL = finalURL
+		tr.Host = parseHost(finalURL)
+	}
+	tr.TLS = tlsInfo(tlsState)
+	if status != 0 {
+		present := declared >= 0
+		tr.ContentLengthPresent = &present
+		if present {
+			tr.DeclaredBytes = declared
+		}
+		tr.ContentEncoding = encoding
+	}
+	if opts.requestSize > 0 && status != 0 {
+		// 206 means the server honored the range; a plain 200 means it
+		// ignored it and streamed from the start.
+		honored := status == http.StatusPartialContent
+		tr.RangeHonored = &honored
+	}
+
+	// If the caller's deadline, rather than our own window, ended the
+	// measurement, the target did not get its full window.
+	tr.CutShort = ctx.Err() != nil
+
+	bytes := counted.N()
+	if bytes == 0 && firstErr != nil {
+		return tr, firstErr
+	}
+	tr.Iters = int(iters)
+	tr.Bytes = bytes
+	if len(counts) > 0 {
+		tr.Responses = counts
+	}
+	tr.IterMinMbps, tr.IterMaxMbps = iterMin, iterMax
+	if setups > 0 {
+		tr.SetupMS = ms(setupTotal / time.Duration(setups))
+	}
+	if iters > 0 {
+		tr.TransferMS = ms(transferTotal / time.Duration(iters))
+	}
+	if s := elapsed.Seconds(); s > 0 {
+		tr.Mbps = float64(bytes) * 8 / 1e6 / s
+		if opts.requestSize > 0 {
+			tr.RPS = float64(iters) / s
+		}
+		if g := goodput.N(); g > 0 {
+			tr.GoodputMbps = float64(g) * 8 / 1e6 / s
+		}
+	}
+	return tr, nil
+}
+
+// fullFileMaxBytes caps a -full-file download so a server that streams
+// indefinitely cannot run forever.
+const fullFileMaxBytes = 1 << 30
+
+// measureFull downloads url exactly once from start to finish and reports
+// the resulting throughput. fast.com files have a known size, so this gives
+// a simple, explainable number at the cost of a fixed (and on fast links,
+// short) measurement window. A non-nil sink additionally receives every
+// body byte — the -verify hasher rides here — at whatever cost the sink's
+// writes add to the measured number.
+func measureFull(ctx context.Context, url string, sink io.Writer) (TargetResult, error) {
+	tr := TargetResult{Host: parseHost(url), URL: url}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return tr, err
+	}
+	start := time.Now()
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return tr, err
+	}
+	if final := resp.Request.URL.String(); final != url {
+		tr.FinalURL = final
+		tr.Host = parseHost(final)
+	}
+	tr.TLS = tlsInfo(resp.TLS)
+	present := resp.ContentLength >= 0
+	tr.ContentLengthPresent = &present
+	if present {
+		tr.DeclaredBytes = resp.ContentLength
+	}
+	tr.ContentEncoding = resp.Header.Get("Content-Encoding")
+	dst := io.Writer(ioutil.Discard)
+	if sink != nil {
+		dst = sink
+	}
+	n, err := copyContext(ctx, dst, io.LimitReader(resp.Body, fullFileMaxBytes), nil)
+	resp.Body.Close()
+	elapsed := time.Since(start)
+	tr.Start, tr.End = start, start.Add(elapsed)
+	if err != nil && n == 0 {
+		return tr, err
+	}
+
+	tr.CutShort = ctx.Err() != nil
+	tr.Iters = 1
+	tr.Bytes = n
+	if s := elapsed.Seconds(); s > 0 {
+		tr.Mbps = float64(n) * 8 / 1e6 / s
+	}
+	return tr, nil
+}
+
+// uploadPayloadSize is how many bytes each upload request sends. Large
+// enough to get past slow start, small enough that a slow uplink still
+// completes requests within the window.
+const uploadPayloadSize = 8 << 20
+
+// measureUpload is measure's mirror image: parallel workers POST generated
+// payloads to url until ctx expires or the window closes. Bytes consumed by
+// the transport from requests cut off by the deadline still count.
+func measureUpload(ctx context.Context, url string, opts *measureOpts) (TargetResult, error) {
+	if opts == nil {
+		opts = new(measureOpts)
+	}
+	payload := opts.payload
+	if payload == nil {
+		payload = zeroPayload(uploadPayloadSize)
+	}
+	tr := TargetResult{Host: parseHost(url), URL: url}
+
+	window := opts.window
+	if window == 0 {
+		window = defaultWindow
+	}
+	wctx, cancel := context.WithTimeout(ctx, window)
+	defer cancel()
+
+	var (
+		counted counter.BytesCounter
+		iters   int64
+
+		mu       sync.Mutex
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for wctx.Err() == nil {
+				if budgetExceeded() {
+					return
+				}
+				body := &countReader{r: payload(), c: &counted}
+				req, err := http.NewRequest("POST", url, body)
+				if err != nil {
+					fail(err)
+					return
+				}
+				req.ContentLength = uploadPayloadSize
+				for k, v := range opts.header {
+					req.Header[k] = v
+				}
+				t0 := time.Now()
+				resp, err := httpClient.Do(req.WithContext(wctx))
+				if err != nil {
+					// Hitting the budget mid-upload truncates the body,
+					// which the transport reports as an error; it isn't one.
+					if wctx.Err() == nil && !budgetExceeded() {
+						fail(err)
+					}
+					return
+				}
+				io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+				atomic.AddInt64(&iters, 1)
+				if opts.onIter != nil {
+					opts.onIter(uploadPayloadSize, time.Since(t0))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	tr.Start, tr.End = start, start.Add(elapsed)
+
+	tr.CutShort = ctx.Err() != nil
+
+	bytes := counted.N()
+	if bytes == 0 && firstErr != nil {
+		return tr, firstErr
+	}
+	tr.Iters = int(iters)
+	if s := elapsed.Seconds(); s > 0 {
+		tr.Mbps = float64(bytes) * 8 / 1e6 / s
+	}
+	return tr, nil
+}
+
+// errKey buckets a request error for the response histogram: timeouts are
+// the interesting intermittent failure, everything else lumps together —
+// the error text itself still surfaces through firstErr.
+func errKey(err error) string {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return "timeout"
+	}
+	return "error"
+}
+
+// countReader counts bytes as the transport consumes them, so partially
+// sent uploads are still accounted for.
+type countReader struct {
+	r io.Reader
+	c *counter.BytesCounter
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	if budgetExceeded() {
+		return 0, io.EOF
+	}
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.c.Add(int64(n))
+		if budgetSpend(int64(n)) && err == nil {
+			err = io.EOF
+		}
+	}
+	return n, err
+}
+
+// preconnect primes a connection to every url with a one-byte request so
+// the measured window does not pay DNS, TCP, and TLS setup costs. The
+// transport keeps the warmed connections alive for the workers to reuse.
+// It returns how long priming took; failures are ignored since the
+// measurement itself will surface them.
+func preconnect(urls []string) time.Duration {
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, u := range urls {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			req, err := http.NewRequest("GET", url, nil)
+			if err != nil {
+				return
+			}
+			req.Header.Set("Range", "bytes=0-0")
+			resp, err := httpClient.Do(req)
+			if err != nil {
+				return
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}(u)
+	}
+	wg.Wait()
+	return time.Since(start)
+}
+
+// splitDeadline bounds ctx by its remaining budget divided evenly among the
+// remaining targets, so a single slow target cannot starve the ones after
+// it. If ctx has no deadline the context is only made cancellable.
+func splitDeadline(ctx context.Context, remaining int) (context.Context, context.CancelFunc) {
+	d, ok := ctx.Deadline()
+	if !ok || remaining <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Until(d)/time.Duration(remaining))
+}
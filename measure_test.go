@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// TestMeasureKnownRate runs the engine against a local server that streams
+// at a known, throttled rate and checks the reported speed against the
+// truth. It exercises byte accounting, concurrency, and timing together —
+// the regressions that matter most (overflow, divide-by-zero, double
+// counting) all show up as a wildly wrong number here.
+func TestMeasureKnownRate(t *testing.T) {
+	const (
+		rateMbps  = 80
+		tolerance = 0.2
+	)
+	// One shared bucket across all connections, so the aggregate rate is
+	// known regardless of worker count.
+	lim := rate.NewLimiter(rateMbps*1e6/8, limiterBurst)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 32<<10)
+		for {
+			if err := lim.WaitN(r.Context(), len(buf)); err != nil {
+				return
+			}
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	tr, err := measure(context.Background(), srv.URL, &measureOpts{window: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off := math.Abs(tr.Mbps-rateMbps) / rateMbps; off > tolerance {
+		t.Errorf("measured %.3f Mbit/s against a %d Mbit/s server (%.1f%% off)",
+			tr.Mbps, rateMbps, off*100)
+	}
+	if tr.Bytes <= 0 {
+		t.Errorf("counted %d bytes, want > 0", tr.Bytes)
+	}
+}
+
+// countingTransport counts round trips on their way to an inner transport.
+type countingTransport struct {
+	rt    http.RoundTripper
+	calls int64
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&c.calls, 1)
+	return c.rt.RoundTrip(req)
+}
+
+// TestMeasureUsesSharedClient checks the engine issues its requests through
+// the package-level httpClient — the one main tunes and api.Load shares —
+// rather than some client of its own.
+func TestMeasureUsesSharedClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 1<<10))
+	}))
+	defer srv.Close()
+
+	ct := &countingTransport{rt: http.DefaultTransport}
+	old := httpClient
+	httpClient = &http.Client{Transport: ct}
+	defer func() { httpClient = old }()
+
+	if _, err := measure(context.Background(), srv.URL, &measureOpts{window: 100 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt64(&ct.calls) == 0 {
+		t.Error("measure never used the shared client")
+	}
+}
+
+// TestMeasureGzipCountsWireBytes serves a body the server insists on
+// gzipping and checks throughput still counts what crossed the wire: the
+// compressed bytes, with the decompressed size reported separately as
+// goodput.
+func TestMeasureGzipCountsWireBytes(t *testing.T) {
+	raw := make([]byte, 1<<20) // zeros compress very well
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(raw)
+	zw.Close()
+	compressed := buf.Bytes()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed)
+	}))
+	defer srv.Close()
+
+	tr, err := measure(context.Background(), srv.URL, &measureOpts{window: 500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.ContentEncoding != "gzip" {
+		t.Errorf("ContentEncoding = %q, want \"gzip\"", tr.ContentEncoding)
+	}
+	if tr.Bytes == 0 || tr.Iters == 0 {
+		t.Fatalf("nothing measured: %d bytes, %d iters", tr.Bytes, tr.Iters)
+	}
+	// Wire bytes are the headline number; a run that counted decompressed
+	// bytes would report roughly len(raw)/len(compressed) times too much.
+	if max := int64(tr.Iters+1) * int64(len(compressed)); tr.Bytes > max {
+		t.Errorf("counted %d bytes over %d iterations, more than %d wire bytes — decompressed bytes leaked in",
+			tr.Bytes, tr.Iters, max)
+	}
+	if tr.GoodputMbps <= tr.Mbps {
+		t.Errorf("goodput %.3f Mbit/s not above wire %.3f Mbit/s despite compression",
+			tr.GoodputMbps, tr.Mbps)
+	}
+	// The two rates must diverge by the compression ratio, within the
+	// slack a partial final download leaves.
+	wantRatio := float64(len(raw)) / float64(len(compressed))
+	gotRatio := tr.GoodputMbps / tr.Mbps
+	if gotRatio < wantRatio*0.8 || gotRatio > wantRatio*1.2 {
+		t.Errorf("goodput/wire ratio = %.1f, want about %.1f", gotRatio, wantRatio)
+	}
+}
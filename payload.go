@@ -0,0 +1,27 @@
+package main
+
+import "io"
+
+// A payloadFactory hands out the body for a single upload request. Each
+// worker must call it for its own io.Reader: the readers are not seekable,
+// so sharing one across goroutines would interleave and corrupt the stream.
+type payloadFactory func() io.Reader
+
+// zeroPayload returns a factory whose readers each yield exactly n zero
+// bytes. Zeros compress well, but fast.com's upload endpoint does not
+// compress request bodies, so they measure the same as random data without
+// burning entropy.
+func zeroPayload(n int64) payloadFactory {
+	return func() io.Reader {
+		return io.LimitReader(zeroReader{}, n)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+// TestPayloadFactoryConcurrent drives one factory from many goroutines the
+// way the upload workers do, checking that every reader yields exactly the
+// intended byte count. A shared non-seekable reader would interleave and
+// shortchange some workers.
+func TestPayloadFactoryConcurrent(t *testing.T) {
+	const (
+		workers = 8
+		size    = 1 << 20
+	)
+	payload := zeroPayload(size)
+
+	var wg sync.WaitGroup
+	got := make([]int64, workers)
+	errs := make([]error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i], errs[i] = io.Copy(ioutil.Discard, payload())
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < workers; i++ {
+		if errs[i] != nil {
+			t.Fatalf("worker %d: %v", i, errs[i])
+		}
+		if got[i] != size {
+			t.Errorf("worker %d sent %d bytes, want %d", i, got[i], size)
+		}
+	}
+}
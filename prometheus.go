@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// metrics holds the most recent completed result for the /metrics endpoint,
+// exposed in OpenMetrics text format so the watch loop doubles as a
+// Prometheus exporter. No client library is worth the dependency for a
+// handful of gauges.
+type metrics struct {
+	// exemplars attaches an OpenMetrics exemplar carrying the run ID to
+	// each throughput sample, so a scrape can be correlated with the run's
+	// logs and structured output. Off by default: not all scrapers accept
+	// exemplar syntax.
+	exemplars bool
+
+	mu  sync.Mutex
+	res *Result
+	// roll is the latest -rolling percentile snapshot; n == 0 (no samples,
+	// or no -rolling at all) keeps the gauges off the page.
+	roll struct {
+		p50, p90, p99 float64
+		n             int
+	}
+}
+
+// set publishes res as the result future scrapes will see.
+func (m *metrics) set(res *Result) {
+	m.mu.Lock()
+	m.res = res
+	m.mu.Unlock()
+}
+
+// setRolling publishes the rolling percentiles computed after a cycle. The
+// watch loop calls it with n == 0 when the window resets, e.g. on an IP
+// change, so stale percentiles disappear rather than linger.
+func (m *metrics) setRolling(p50, p90, p99 float64, n int) {
+	m.mu.Lock()
+	m.roll.p50, m.roll.p90, m.roll.p99, m.roll.n = p50, p90, p99, n
+	m.mu.Unlock()
+}
+
+// ServeHTTP implements /metrics: the latest result as OpenMetrics gauges,
+// 503 until the first cycle completes.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	res, roll := m.res, m.roll
+	m.mu.Unlock()
+	if res == nil {
+		http.Error(w, "no measurement completed yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+
+	// An exemplar rides after the sample it annotates: its run_id label
+	// points at the run's logs, its value repeats the measurement. The
+	// gauges themselves are identical either way.
+	ex := func(v float64) string {
+		if !m.exemplars {
+			return ""
+		}
+		return fmt.Sprintf(" # {run_id=\"%s\"} %g", escapeLabel(res.RunID), v)
+	}
+
+	fmt.Fprintln(w, "# TYPE fast_mean_mbps gauge")
+	fmt.Fprintln(w, "# HELP fast_mean_mbps Weighted mean download speed across targets in Mbit/s.")
+	fmt.Fprintf(w, "fast_mean_mbps %g%s\n", res.Mean, ex(res.Mean))
+
+	fmt.Fprintln(w, "# TYPE fast_target_mbps gauge")
+	fmt.Fprintln(w, "# HELP fast_target_mbps Per-target download speed in Mbit/s.")
+	for _, t := range res.Targets {
+		fmt.Fprintf(w, "fast_target_mbps{host=\"%s\"} %g%s\n", escapeLabel(t.Host), t.Mbps, ex(t.Mbps))
+	}
+	if res.hasUpload() {
+		fmt.Fprintln(w, "# TYPE fast_target_upload_mbps gauge")
+		fmt.Fprintln(w, "# HELP fast_target_upload_mbps Per-target upload speed in Mbit/s.")
+		for _, t := range res.Targets {
+			if t.UploadMbps == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "fast_target_upload_mbps{host=\"%s\"} %g%s\n", escapeLabel(t.Host), t.UploadMbps, ex(t.UploadMbps))
+		}
+	}
+
+	if roll.n > 0 {
+		for _, g := range []struct {
+			name string
+			p    int
+			v    float64
+		}{
+			{"fast_rolling_p50_mbps", 50, roll.p50},
+			{"fast_rolling_p90_mbps", 90, roll.p90},
+			{"fast_rolling_p99_mbps", 99, roll.p99},
+		} {
+			fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+			fmt.Fprintf(w, "# HELP %s %dth percentile of recent cycle means over the -rolling window, in Mbit/s.\n", g.name, g.p)
+			fmt.Fprintf(w, "%s %g\n", g.name, g.v)
+		}
+		fmt.Fprintln(w, "# TYPE fast_rolling_samples gauge")
+		fmt.Fprintln(w, "# HELP fast_rolling_samples How many cycle means the -rolling window currently holds.")
+		fmt.Fprintf(w, "fast_rolling_samples %d\n", roll.n)
+	}
+
+	fmt.Fprintln(w, "# TYPE fast_last_run_timestamp_seconds gauge")
+	fmt.Fprintln(w, "# HELP fast_last_run_timestamp_seconds When the exposed result finished, as a Unix timestamp.")
+	fmt.Fprintf(w, "fast_last_run_timestamp_seconds %d\n", res.End.Unix())
+
+	fmt.Fprintln(w, "# EOF")
+}
+
+// escapeLabel escapes s for use inside a double-quoted OpenMetrics label
+// value.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
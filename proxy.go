@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// socks5Client returns a copy of c that routes all connections through the
+// SOCKS5 proxy described by spec ("host:port" or "user:pass@host:port"),
+// e.g. the local end of an ssh -D tunnel. Both the config fetch and the
+// downloads should use the returned client. Only the dialer changes: the
+// transport keeps c's tuning, in particular DisableCompression, without
+// which the byte counts would stop meaning wire bytes.
+func socks5Client(c *http.Client, spec string) (*http.Client, error) {
+	var auth *proxy.Auth
+	addr := spec
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		cred := spec[:i]
+		addr = spec[i+1:]
+		user, pass := cred, ""
+		if j := strings.Index(cred, ":"); j >= 0 {
+			user, pass = cred[:j], cred[j+1:]
+		}
+		auth = &proxy.Auth{User: user, Password: pass}
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid SOCKS5 proxy address %q: %v", addr, err)
+	}
+
+	// Fail early with a useful error instead of letting every request
+	// time out against a dead proxy.
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("SOCKS5 proxy unreachable: %v", err)
+	}
+	conn.Close()
+
+	d, err := proxy.SOCKS5("tcp", addr, auth, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	tr := transport(c)
+	if tr == nil {
+		return nil, errors.New("-socks5: client transport is not an *http.Transport")
+	}
+	// The proxy dialer predates DialContext; adapt it so proxied
+	// connections are still counted.
+	tr.DialContext = countConns(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return d.Dial(network, addr)
+	})
+	cc := *c
+	cc.Transport = tr
+	return &cc, nil
+}
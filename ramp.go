@@ -0,0 +1,54 @@
+package main
+
+import "time"
+
+// sampleInterval is the spacing of the throughput time series whenever
+// sampling is on (-sparkline, -detect-throttle, -ramp). Coarse enough that
+// the sampler itself is free, fine enough to see slow start happen.
+const sampleInterval = 250 * time.Millisecond
+
+const (
+	// rampWindow is how many consecutive samples must average out to a
+	// level before it counts, for both the peak and the ramp point. A
+	// single spike is noise, not saturation.
+	rampWindow = 3
+	// rampFraction of the peak is "saturated". 90% splits the difference
+	// between catching the knee of the curve and chasing jitter at the top.
+	rampFraction = 0.9
+)
+
+// rampTime reports how long after measurement start the throughput first
+// sustained rampFraction of its peak, where both "peak" and "sustained"
+// mean a rampWindow-sample moving average. It returns 0 when the series is
+// too short to say — slow start may simply not have finished within the
+// window.
+func rampTime(samples []float64, every time.Duration) time.Duration {
+	if len(samples) < rampWindow || every <= 0 {
+		return 0
+	}
+	smoothed := make([]float64, len(samples)-rampWindow+1)
+	for i := range smoothed {
+		var sum float64
+		for _, s := range samples[i : i+rampWindow] {
+			sum += s
+		}
+		smoothed[i] = sum / rampWindow
+	}
+	var peak float64
+	for _, s := range smoothed {
+		if s > peak {
+			peak = s
+		}
+	}
+	if peak == 0 {
+		return 0
+	}
+	for i, s := range smoothed {
+		if s >= rampFraction*peak {
+			// The window ending at sample i+rampWindow is the first to
+			// sustain the level, so that sample's timestamp is the answer.
+			return time.Duration(i+rampWindow) * every
+		}
+	}
+	return 0
+}
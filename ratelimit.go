@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterBurst is the token bucket size used for rate limiting. It also
+// caps how many bytes a single Read may consume, since a wait for more than
+// the burst would never be satisfied.
+const limiterBurst = 256 << 10
+
+// newLimiterFactory returns a factory the measurement workers call to get
+// their rate limiter, throttling downloads to mbps Mbit/s. If perWorker is
+// false every worker shares one bucket, so mbps caps the aggregate rate;
+// otherwise each worker gets its own bucket.
+func newLimiterFactory(mbps float64, perWorker bool) func() *rate.Limiter {
+	bps := rate.Limit(mbps * 1e6 / 8)
+	if perWorker {
+		return func() *rate.Limiter { return rate.NewLimiter(bps, limiterBurst) }
+	}
+	shared := rate.NewLimiter(bps, limiterBurst)
+	return func() *rate.Limiter { return shared }
+}
+
+// limitedReader throttles reads from r to lim's rate, blocking after each
+// read until the bucket refills or ctx is cancelled.
+type limitedReader struct {
+	r   io.Reader
+	lim *rate.Limiter
+	ctx context.Context
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > l.lim.Burst() {
+		p = p[:l.lim.Burst()]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if werr := l.lim.WaitN(l.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// rolling keeps each -watch cycle's mean for a bounded retention window so
+// long monitoring sessions can report stable, SLO-style percentiles
+// alongside the instantaneous numbers.
+type rolling struct {
+	retention time.Duration
+	t         []time.Time
+	v         []float64
+}
+
+func newRolling(retention time.Duration) *rolling {
+	return &rolling{retention: retention}
+}
+
+// add records v at now and drops entries older than the retention. Entries
+// arrive in time order, one per cycle, so expiry is a prefix trim.
+func (r *rolling) add(now time.Time, v float64) {
+	r.t = append(r.t, now)
+	r.v = append(r.v, v)
+	cut := now.Add(-r.retention)
+	i := 0
+	for i < len(r.t) && r.t[i].Before(cut) {
+		i++
+	}
+	r.t = r.t[i:]
+	r.v = r.v[i:]
+}
+
+// reset drops all history, e.g. after the public IP changes and the old
+// samples stop being comparable.
+func (r *rolling) reset() { r.t, r.v = nil, nil }
+
+// n reports how many samples the window currently holds.
+func (r *rolling) n() int { return len(r.v) }
+
+// percentile returns the pth percentile (0-100) of the retained values by
+// nearest rank, or 0 when the window is empty.
+func (r *rolling) percentile(p float64) float64 {
+	if len(r.v) == 0 {
+		return 0
+	}
+	s := append([]float64(nil), r.v...)
+	sort.Float64s(s)
+	i := int(math.Ceil(p/100*float64(len(s)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(s) {
+		i = len(s) - 1
+	}
+	return s[i]
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// rotatingFile is a file sink that rotates itself by size or age, for
+// -watch runs that write results for days. The live file always keeps the
+// configured name; on rotation it is renamed aside with a timestamp suffix
+// and a fresh file takes its place, so tail -f and log shippers keep
+// working. Either threshold may be zero to disable it.
+type rotatingFile struct {
+	mu       sync.Mutex
+	name     string
+	maxSize  int64
+	interval time.Duration
+	f        *os.File
+	size     int64
+	opened   time.Time
+}
+
+// openRotatingFile opens (or resumes) name for appending. Existing content
+// counts toward the size threshold, so resuming a long watch does not reset
+// the clock on a nearly full file.
+func openRotatingFile(name string, maxSize int64, interval time.Duration) (*rotatingFile, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &rotatingFile{
+		name:     name,
+		maxSize:  maxSize,
+		interval: interval,
+		f:        f,
+		size:     fi.Size(),
+		opened:   time.Now(),
+	}, nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	due := (r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize) ||
+		(r.interval > 0 && time.Since(r.opened) >= r.interval)
+	// Rotate between writes, never mid-record, and only once the file has
+	// content — an oversized first record still lands somewhere.
+	if due && r.size > 0 {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate renames the live file aside (name.20060102-150405) and reopens a
+// fresh one under the configured name.
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(r.name, r.name+"."+time.Now().Format("20060102-150405")); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	r.size = 0
+	r.opened = time.Now()
+	return nil
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
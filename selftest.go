@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+
+	"golang.org/x/time/rate"
+)
+
+// selftestRate is the rate the local self-test server serves at, in Mbit/s,
+// and selftestTolerance is how far the measured number may stray from it.
+const (
+	selftestRate      = 80
+	selftestTolerance = 0.15
+)
+
+// selftest stands up a local server that streams data at a known, throttled
+// rate, measures it with the normal engine, and checks the reported speed
+// against the truth. It exercises byte accounting, concurrency, and timing
+// end to end without touching the network, guarding against regressions in
+// the core math.
+func selftest() error {
+	// One shared bucket across all connections, so the aggregate rate is
+	// known regardless of worker count.
+	lim := rate.NewLimiter(selftestRate*1e6/8, limiterBurst)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 32<<10)
+		for {
+			if err := lim.WaitN(r.Context(), len(buf)); err != nil {
+				return
+			}
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	tr, err := measure(context.Background(), srv.URL, nil)
+	if err != nil {
+		return err
+	}
+	off := math.Abs(tr.Mbps-selftestRate) / selftestRate
+	if off > selftestTolerance {
+		return fmt.Errorf("selftest: measured %.3f Mbit/s against a %d Mbit/s server (%.1f%% off)",
+			tr.Mbps, selftestRate, off*100)
+	}
+	fmt.Printf("selftest ok: measured %.3f Mbit/s against a %d Mbit/s server\n", tr.Mbps, selftestRate)
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// fileSettings are optional defaults read from the settings file. Keys
+// mirror flag names; unknown keys are an error so a typo doesn't silently
+// do nothing.
+type fileSettings struct {
+	Token     string `json:"token"`
+	UserAgent string `json:"user-agent"`
+	URLs      int    `json:"urls"`
+	Timeout   string `json:"timeout"`
+	Format    string `json:"format"` // "table", "json", or "influx"
+}
+
+// settingsPath returns $FAST_CONFIG if set, falling back to
+// ~/.config/fast/config.json.
+func settingsPath() string {
+	if p := os.Getenv("FAST_CONFIG"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "fast", "config.json")
+}
+
+// loadSettings reads the settings file. A missing file is not an error:
+// the settings are simply empty.
+func loadSettings() (*fileSettings, error) {
+	var s fileSettings
+	path := settingsPath()
+	if path == "" {
+		return &s, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &s, nil
+}
+
+// applySettings fills in flags the user did not pass explicitly, first from
+// the environment (FAST_TOKEN, FAST_USER_AGENT, ...) and then from the
+// settings file, so the precedence is flags > env > file > built-in
+// defaults. It must be called after flag.Parse.
+func applySettings(s *fileSettings) error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	apply := func(name, filev string) error {
+		if set[name] {
+			return nil
+		}
+		v := os.Getenv("FAST_" + strings.ToUpper(strings.Replace(name, "-", "_", -1)))
+		if v == "" {
+			v = filev
+		}
+		if v == "" {
+			return nil
+		}
+		if err := flag.Set(name, v); err != nil {
+			return fmt.Errorf("setting %s: %v", name, err)
+		}
+		return nil
+	}
+	var urls string
+	if s.URLs != 0 {
+		urls = strconv.Itoa(s.URLs)
+	}
+	for _, kv := range []struct{ name, val string }{
+		{"token", s.Token},
+		{"user-agent", s.UserAgent},
+		{"urls", urls},
+		{"timeout", s.Timeout},
+	} {
+		if err := apply(kv.name, kv.val); err != nil {
+			return err
+		}
+	}
+
+	// The output format maps onto the per-format flags rather than a flag
+	// of its own.
+	format := os.Getenv("FAST_FORMAT")
+	if format == "" {
+		format = s.Format
+	}
+	if format != "" && !set["json"] && !set["influx"] {
+		switch format {
+		case "table":
+			// The default.
+		case "json":
+			flag.Set("json", "true")
+		case "influx":
+			flag.Set("influx", "true")
+		default:
+			return fmt.Errorf("unknown format %q", format)
+		}
+	}
+	return nil
+}
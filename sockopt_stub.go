@@ -0,0 +1,13 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import "syscall"
+
+// rcvbufSupported reports whether this platform can set SO_RCVBUF.
+const rcvbufSupported = false
+
+// setRcvbuf is a no-op on platforms where the option is unavailable; main
+// warns instead of silently measuring something other than what was asked.
+func setRcvbuf(c syscall.RawConn, bytes int) error { return nil }
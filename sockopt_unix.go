@@ -0,0 +1,23 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import "syscall"
+
+// rcvbufSupported reports whether this platform can set SO_RCVBUF.
+const rcvbufSupported = true
+
+// setRcvbuf sets SO_RCVBUF on the raw connection. Note the kernel is free
+// to clamp the value to its configured maximum (net.core.rmem_max on
+// Linux), and Linux additionally doubles it for bookkeeping; what was
+// actually granted is visible in ss/netstat, not here.
+func setRcvbuf(c syscall.RawConn, bytes int) error {
+	var serr error
+	if err := c.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, bytes)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
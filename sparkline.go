@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders x as a unicode sparkline scaled to its own maximum,
+// giving a quick visual of ramp and stability.
+func sparkline(x []float64) string {
+	var max float64
+	for _, v := range x {
+		if v > max {
+			max = v
+		}
+	}
+	if max == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, v := range x {
+		i := int(v / max * float64(len(sparks)-1))
+		if i < 0 {
+			i = 0
+		}
+		b.WriteRune(sparks[i])
+	}
+	return b.String()
+}
+
+// isTTY reports whether f is attached to a terminal.
+func isTTY(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
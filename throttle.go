@@ -0,0 +1,40 @@
+package main
+
+// defaultThrottleFactor flags a throttle when the plateau is below this
+// fraction of the early peak.
+const defaultThrottleFactor = 0.6
+
+// Throttle describes a burst-then-throttle pattern: an early peak followed
+// by a sustained, significantly lower plateau.
+type Throttle struct {
+	Peak    float64 `json:"peak_mbps"`
+	Plateau float64 `json:"plateau_mbps"`
+}
+
+// detectThrottle inspects a target's throughput time series for the classic
+// ISP shaping signature. The peak is the highest sample in the first half
+// of the window; the plateau is the average of the final third. A plateau
+// below factor*peak is reported as possible throttling. This is a
+// heuristic: short windows and noisy links will fool it.
+func detectThrottle(samples []float64, factor float64) *Throttle {
+	if len(samples) < 6 {
+		return nil
+	}
+	var peak float64
+	for _, s := range samples[:len(samples)/2] {
+		if s > peak {
+			peak = s
+		}
+	}
+	var plateau float64
+	tail := samples[len(samples)-len(samples)/3:]
+	for _, s := range tail {
+		plateau += s
+	}
+	plateau /= float64(len(tail))
+
+	if peak == 0 || plateau >= factor*peak {
+		return nil
+	}
+	return &Throttle{Peak: peak, Plateau: plateau}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// timingsLog streams one CSV row per completed request to a file, for
+// offline analysis of the raw HTTP timings behind the aggregate numbers.
+// Rows are written (and flushed) as requests complete, so memory stays flat
+// no matter how long the run is; the mutex serializes the worker goroutines
+// that feed it.
+type timingsLog struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *csv.Writer
+}
+
+// openTimingsLog creates name and writes the CSV header. The columns are
+// the request's start time, time to first response byte, total duration,
+// and bytes transferred.
+func openTimingsLog(name string) (*timingsLog, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"host", "start", "ttfb_ms", "total_ms", "bytes"}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.Flush()
+	return &timingsLog{f: f, w: w}, nil
+}
+
+// record writes one request's row. Safe for concurrent use.
+func (l *timingsLog) record(t reqTiming) {
+	row := []string{
+		parseHost(t.url),
+		t.start.Format(time.RFC3339Nano),
+		strconv.FormatFloat(ms(t.ttfb), 'f', 3, 64),
+		strconv.FormatFloat(ms(t.total), 'f', 3, 64),
+		strconv.FormatInt(t.bytes, 10),
+	}
+	l.mu.Lock()
+	l.w.Write(row)
+	l.w.Flush()
+	l.mu.Unlock()
+}
+
+// Close flushes and closes the log, surfacing any write error csv.Writer
+// swallowed along the way.
+func (l *timingsLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Flush()
+	if err := l.w.Error(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// TLSInfo describes the TLS parameters negotiated with a target, useful for
+// confirming TLS 1.3 usage or explaining performance differences between
+// endpoints.
+type TLSInfo struct {
+	// Version is the negotiated protocol version, e.g. "TLS 1.3".
+	Version string `json:"version"`
+	// Cipher is the negotiated cipher suite.
+	Cipher string `json:"cipher"`
+	// Proto is the ALPN-negotiated application protocol, e.g. "h2".
+	Proto string `json:"proto,omitempty"`
+}
+
+// tlsInfo summarizes the connection state from a response, or returns nil
+// for plaintext connections, which simply lack the field.
+func tlsInfo(cs *tls.ConnectionState) *TLSInfo {
+	if cs == nil {
+		return nil
+	}
+	return &TLSInfo{
+		Version: tlsVersionName(cs.Version),
+		Cipher:  tls.CipherSuiteName(cs.CipherSuite),
+		Proto:   cs.NegotiatedProtocol,
+	}
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// newClient returns the client shared by the config fetch and the
+// measurement engine. Sharing one client means one connection pool and one
+// set of DNS lookups across both phases, which matters in -watch mode where
+// runs repeat. It is a tuned clone of the default transport rather than
+// http.DefaultClient itself, so measurement traffic never competes with
+// connections owned by other packages.
+func newClient() *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	// The workers all hammer the same few hosts; keep every warmed
+	// connection instead of the default two per host.
+	tr.MaxIdleConnsPerHost = tr.MaxIdleConns
+	tr.DialContext = countConns(tr.DialContext)
+	// Throughput must count what crossed the network. Transparent gzip
+	// would silently count decompressed bytes instead, so never ask for
+	// compression and never undo it.
+	tr.DisableCompression = true
+	return &http.Client{Transport: tr}
+}
+
+// transport returns c's underlying *http.Transport, cloning the default
+// transport when c doesn't have its own, or nil if c uses some other
+// RoundTripper we can't reach inside.
+func transport(c *http.Client) *http.Transport {
+	switch t := c.Transport.(type) {
+	case nil:
+		return http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		return t.Clone()
+	}
+	return nil
+}
+
+// unixSocketClient returns a copy of c that dials the Unix socket at path
+// no matter what host a URL names. It exists so the measurement engine can
+// be benchmarked deterministically against a local server without TCP; it
+// is not meant for production use. Everything but the dialer keeps c's
+// tuning — a harness that transparently gunzips would measure the wrong
+// bytes, defeating its own purpose.
+func unixSocketClient(c *http.Client, path string) *http.Client {
+	tr := transport(c)
+	if tr == nil {
+		return c
+	}
+	tr.DialContext = countConns(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	})
+	cc := *c
+	cc.Transport = tr
+	return &cc
+}
+
+// httpProxyClient returns a copy of c that tunnels through the HTTP proxy
+// at rawurl ([user:pass@]host:port, scheme optional). Setting Proxy alone is
+// not enough when the proxy wants credentials: HTTPS targets go through a
+// CONNECT tunnel, and the Proxy-Authorization header must ride the CONNECT
+// itself — via ProxyConnectHeader — or the proxy answers 407 before any
+// measurement request is made.
+func httpProxyClient(c *http.Client, rawurl string) (*http.Client, error) {
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "http://" + rawurl
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("-proxy: %v", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("-proxy: %q has no host", rawurl)
+	}
+	tr := transport(c)
+	if tr == nil {
+		return nil, errors.New("-proxy: client transport is not an *http.Transport")
+	}
+	tr.Proxy = http.ProxyURL(u)
+	if u.User != nil {
+		user := u.User.Username()
+		pass, _ := u.User.Password()
+		cred := base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+		tr.ProxyConnectHeader = http.Header{
+			"Proxy-Authorization": {"Basic " + cred},
+		}
+	}
+	cc := *c
+	cc.Transport = tr
+	return &cc, nil
+}
+
+// rcvbufClient returns a copy of c whose dialer requests an SO_RCVBUF of
+// bytes on every connection, before the handshake so the window scaling
+// negotiation sees it. The receive window TCP advertises derives from this
+// buffer, so on high-BDP paths the kernel's default (or an administrator's
+// clamp) can be the real bottleneck; this lets tuned hosts prove it.
+func rcvbufClient(c *http.Client, bytes int) *http.Client {
+	tr := transport(c)
+	if tr == nil {
+		return c
+	}
+	d := &net.Dialer{
+		Control: func(network, address string, rc syscall.RawConn) error {
+			return setRcvbuf(rc, bytes)
+		},
+	}
+	tr.DialContext = countConns(d.DialContext)
+	cc := *c
+	cc.Transport = tr
+	return &cc
+}
+
+// withResolver returns a copy of c that resolves every hostname through the
+// DNS server at addr (host:port) instead of the system resolver. A local
+// resolver that hijacks or mislocates lookups skews GeoDNS target selection;
+// pointing at a known-good server sidesteps it.
+func withResolver(c *http.Client, addr string) *http.Client {
+	tr := transport(c)
+	if tr == nil {
+		return c
+	}
+	d := &net.Dialer{
+		Resolver: &net.Resolver{
+			// The Go resolver is the only one that honors a custom Dial.
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var rd net.Dialer
+				return rd.DialContext(ctx, network, addr)
+			},
+		},
+	}
+	tr.DialContext = countConns(d.DialContext)
+	cc := *c
+	cc.Transport = tr
+	return &cc
+}
+
+// forceNetwork returns a copy of c that dials only the given network
+// ("tcp4" or "tcp6"), pinning every connection to one address family.
+func forceNetwork(c *http.Client, network string) *http.Client {
+	tr := transport(c)
+	if tr == nil {
+		return c
+	}
+	dial := tr.DialContext
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	tr.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dial(ctx, network, addr)
+	}
+	cc := *c
+	cc.Transport = tr
+	return &cc
+}
+
+// noRedirectClient returns a copy of c that treats any redirect as an
+// error, for users who want to know when a CDN is bouncing them elsewhere
+// rather than silently measuring the destination.
+func noRedirectClient(c *http.Client) *http.Client {
+	cc := *c
+	cc.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return fmt.Errorf("refusing redirect to %s", req.URL)
+	}
+	return &cc
+}
+
+// disableHTTP2 returns a copy of c whose transport never negotiates HTTP/2.
+// Some middleboxes mangle h2; forcing HTTP/1.1 is the standard workaround
+// when negotiation succeeds but transfers misbehave.
+func disableHTTP2(c *http.Client) *http.Client {
+	tr := transport(c)
+	if tr == nil {
+		return c
+	}
+	// A non-nil empty map tells the transport h2 is unavailable.
+	tr.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	cc := *c
+	cc.Transport = tr
+	return &cc
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestProxyAuthRidesConnect stands up a bare TCP listener posing as an HTTP
+// proxy and checks that an HTTPS request through httpProxyClient sends its
+// Proxy-Authorization header on the CONNECT itself — the whole reason the
+// helper sets ProxyConnectHeader rather than just Proxy.
+func TestProxyAuthRidesConnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	got := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			got <- "read: " + err.Error()
+			return
+		}
+		if req.Method != "CONNECT" {
+			got <- "method: " + req.Method
+			return
+		}
+		got <- req.Header.Get("Proxy-Authorization")
+		// Refuse the tunnel; the client request fails, which is fine —
+		// the CONNECT already told us what we needed.
+		conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+	}()
+
+	hc, err := httpProxyClient(newClient(), "user:secret@"+ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc.Timeout = 5 * time.Second
+	if _, err := hc.Get("https://fast.invalid/"); err == nil {
+		t.Error("request through a refusing proxy succeeded unexpectedly")
+	}
+
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	select {
+	case auth := <-got:
+		if auth != want {
+			t.Errorf("CONNECT Proxy-Authorization = %q, want %q", auth, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy never saw a CONNECT")
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Verbosity levels for diagnostic output. Each level includes everything
+// below it: quiet is results only, info adds run context (ISP, location,
+// warnings), debug adds per-target detail (TLS parameters, file sizes), and
+// trace is reserved for per-request noise.
+const (
+	levelQuiet = iota
+	levelInfo
+	levelDebug
+	levelTrace
+)
+
+// verbosity is the global diagnostic level. main sets it once from
+// -verbose-level before any measurement starts.
+var verbosity = levelQuiet
+
+// verbose reports whether diagnostics at level should be produced.
+func verbose(level int) bool { return verbosity >= level }
+
+// logf writes a diagnostic line to stderr when level is enabled. Results
+// still go to stdout; this is only for the commentary around them.
+func logf(level int, format string, args ...interface{}) {
+	if verbosity >= level {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
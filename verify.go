@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// loadChecksums parses a sha256sum-style file of expected target hashes:
+// one "<hex> <url>" pair per line, blank lines and #-comments skipped. The
+// URL must match the target URL exactly, since that is the only name both
+// sides share.
+func loadChecksums(name string) (map[string]string, error) {
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	sums := make(map[string]string)
+	for i, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 2 || len(f[0]) != hex.EncodedLen(sha256.Size) {
+			return nil, fmt.Errorf("%s:%d: want \"<sha256-hex> <url>\"", name, i+1)
+		}
+		if _, err := hex.DecodeString(f[0]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, i+1, err)
+		}
+		sums[f[1]] = strings.ToLower(f[0])
+	}
+	if len(sums) == 0 {
+		return nil, fmt.Errorf("%s: no checksums", name)
+	}
+	return sums, nil
+}
+
+// checksumError is a verification failure — the bytes that arrived are not
+// the bytes the checksum file promised. It gets its own type so callers can
+// tell corruption apart from the network failures they might retry.
+type checksumError struct {
+	host, want, got string
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("%s: checksum mismatch: got sha256 %s, want %s (corrupting proxy or wrong file?)", e.host, e.got, e.want)
+}
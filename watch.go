@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// health tracks the outcome of the most recent measurement cycle so
+// orchestrators can restart a stuck or failing exporter.
+type health struct {
+	// interval is the expected spacing between cycles; a result older
+	// than two intervals is considered stale.
+	interval time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+	err  error
+}
+
+// set records the outcome of a cycle that just finished.
+func (h *health) set(err error) {
+	h.mu.Lock()
+	h.last = time.Now()
+	h.err = err
+	h.mu.Unlock()
+}
+
+// ServeHTTP implements /healthz: 200 when the last cycle is fresh and
+// succeeded, 503 otherwise with the reason in the body.
+func (h *health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	last, err := h.last, h.err
+	h.mu.Unlock()
+
+	switch {
+	case last.IsZero():
+		http.Error(w, "no measurement completed yet", http.StatusServiceUnavailable)
+	case err != nil:
+		http.Error(w, fmt.Sprintf("last measurement failed: %v", err), http.StatusServiceUnavailable)
+	case time.Since(last) > 2*h.interval:
+		http.Error(w, fmt.Sprintf("last measurement is stale (%v ago)", time.Since(last)), http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintf(w, "ok; last measurement %v ago\n", time.Since(last))
+	}
+}
+
+// serveHealth serves /healthz on addr in the background, and /metrics too
+// when m is non-nil.
+func serveHealth(addr string, h *health, m *metrics) {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", h)
+	if m != nil {
+		mux.Handle("/metrics", m)
+	}
+	go func() {
+		log.Fatal(http.ListenAndServe(addr, mux))
+	}()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// webhookTimeout bounds the webhook POST when the run has no -timeout of
+// its own; a hung collector should never wedge the measurement loop.
+const webhookTimeout = 30 * time.Second
+
+// postWebhook POSTs res to url as JSON — byte for byte the same payload
+// -json prints, so a collector can treat both sources identically. hdr
+// carries extra headers (auth, typically); timeout 0 falls back to
+// webhookTimeout.
+func postWebhook(url string, res *Result, hdr http.Header, timeout time.Duration) error {
+	body, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	if timeout == 0 {
+		timeout = webhookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}